@@ -16,8 +16,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"./printer"
+	"github.com/bitlaunchio/gobitlaunch"
 
 	"github.com/spf13/cobra"
 )
@@ -44,11 +48,31 @@ func Account() *cobra.Command {
 	cmd.AddCommand(accountShow)
 	cmd.AddCommand(accountUsage)
 	cmd.AddCommand(accountHistory)
+	cmd.AddCommand(accountTopup)
+	cmd.AddCommand(accountForecast)
 
 	accountUsage.Flags().StringP("period", "p", "latest", "filter for period, format: YYYY-MM or latest")
+	accountUsage.Flags().String("from", "", "first period of a range, format: YYYY-MM")
+	accountUsage.Flags().String("to", "", "last period of a range, format: YYYY-MM (default is the current month)")
+	accountUsage.Flags().StringP("format", "f", "json", "output format for ranges: json, table or csv")
+	accountUsage.Flags().Int("concurrency", 4, "how many periods to fetch at once with --from")
 
 	accountHistory.Flags().IntP("page", "p", 1, "page number of history results to show")
 	accountHistory.Flags().IntP("items", "i", 25, "how many history results to show")
+	accountHistory.Flags().BoolP("all", "a", false, "fetch every page of history")
+	accountHistory.Flags().Int("concurrency", 4, "how many pages to fetch at once with --all")
+	accountHistory.Flags().String("since", "", "only show history from this date, format: YYYY-MM-DD")
+	accountHistory.Flags().String("until", "", "only show history up to this date, format: YYYY-MM-DD")
+
+	accountTopup.Flags().Float64("below", 0, "top up when the balance in USD is below this amount")
+	accountTopup.Flags().Int("amount", 0, "amount in USD to top up by")
+	accountTopup.Flags().String("coin", "BTC", "cryptocurrency to pay with: BTC, LTC, ETH, BCH, NANO, TRX, SRN or TEL")
+	accountTopup.Flags().BoolP("lightning", "l", false, "use the lightning network, valid for BTC and LTC")
+	accountTopup.Flags().Bool("qr", false, "also output the payment as a qr code to the terminal")
+	accountTopup.MarkFlagRequired("below")
+	accountTopup.MarkFlagRequired("amount")
+
+	accountForecast.Flags().StringP("format", "f", "table", "output format: table or json")
 
 	return cmd
 }
@@ -71,9 +95,21 @@ var accountShow = &cobra.Command{
 var accountUsage = &cobra.Command{
 	Use:   "usage",
 	Short: "Retrieve account usage information",
-	Long:  ``,
+	Long: `usage [--period YYYY-MM|latest]
+usage --from YYYY-MM [--to YYYY-MM] [--format json|table|csv]
+
+With --from, every period in the range is fetched and the cost is totalled
+per period, provider and server, with the change from the previous month.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		period, _ := cmd.Flags().GetString("period")
+		from, _ := cmd.Flags().GetString("from")
+		if len(from) > 0 {
+			to, _ := cmd.Flags().GetString("to")
+			format, _ := cmd.Flags().GetString("format")
+			concurrency, _ := cmd.Flags().GetInt("concurrency")
+			usageRange(from, to, format, concurrency)
+			return
+		}
 
 		usage, err := client.Account.Usage(period)
 
@@ -89,17 +125,422 @@ var accountUsage = &cobra.Command{
 var accountHistory = &cobra.Command{
 	Use:   "history",
 	Short: "Retrieve account history information",
-	Long:  ``,
+	Long:  `history [--page 1 --items 25 | --all] [--since YYYY-MM-DD] [--until YYYY-MM-DD]`,
 	Run: func(cmd *cobra.Command, args []string) {
 		page, _ := cmd.Flags().GetInt("page")
 		items, _ := cmd.Flags().GetInt("items")
+		all, _ := cmd.Flags().GetBool("all")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+
+		dates, err := parseDateRange(since, until, time.UTC)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
+		if all {
+			page = 1
+		}
 		history, err := client.Account.History(page, items)
 		if err != nil {
 			fmt.Printf("Error getting account history information : %v", err)
 			os.Exit(1)
 		}
 
+		if all && items > 0 {
+			first := history
+			pages := (first.Total + items - 1) / items
+			if pages < 1 {
+				pages = 1
+			}
+
+			var mu sync.Mutex
+			results := map[int][]gobitlaunch.HistoryItem{1: first.History}
+			err := fetchPages(pages, items, concurrency, func(page int) (int, error) {
+				if page == 1 {
+					return len(first.History), nil
+				}
+				h, err := client.Account.History(page, items)
+				if err != nil {
+					return 0, err
+				}
+				mu.Lock()
+				results[page] = h.History
+				mu.Unlock()
+				return len(h.History), nil
+			})
+			if err != nil {
+				fmt.Printf("Error getting account history information : %v", err)
+				os.Exit(1)
+			}
+
+			history = &gobitlaunch.AccountHistory{}
+			seen := map[string]bool{}
+			for p := 1; p <= pages; p++ {
+				for _, item := range results[p] {
+					if !seen[item.ID] {
+						seen[item.ID] = true
+						history.History = append(history.History, item)
+					}
+				}
+			}
+		}
+
+		filtered := []gobitlaunch.HistoryItem{}
+		for _, item := range history.History {
+			if dates.includes(item.Time) {
+				filtered = append(filtered, item)
+			}
+		}
+		if all || len(since) > 0 || len(until) > 0 {
+			history.History = filtered
+			history.Total = len(filtered)
+		}
+
 		printer.Output(history)
 	},
 }
+
+// topupState records the last invoice created by account topup
+type topupState struct {
+	TransactionID string `json:"transactionId"`
+}
+
+var accountTopup = &cobra.Command{
+	Use:   "topup",
+	Short: "Create a payment when the account balance is low",
+	Long: `topup --below <usd> --amount <usd> [--coin BTC]
+
+Creates a transaction for --amount when the balance is below --below and
+outputs its payment URI. While that invoice is still open, later runs output
+it again instead of creating another, so topup can run from cron.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		below, _ := cmd.Flags().GetFloat64("below")
+		amount, _ := cmd.Flags().GetInt("amount")
+		coin, _ := cmd.Flags().GetString("coin")
+		ln, _ := cmd.Flags().GetBool("lightning")
+		qr, _ := cmd.Flags().GetBool("qr")
+
+		coin = strings.ToUpper(coin)
+		if !contains(cryptoSymbols, coin) {
+			fmt.Printf("Unsupported cryptocurrency %s, must be one of: %s\n", coin, strings.Join(cryptoSymbols, ", "))
+			os.Exit(1)
+		}
+		if amount <= 0 {
+			fmt.Println("Please specify a positive --amount")
+			os.Exit(1)
+		}
+
+		account, err := client.Account.Show()
+		if err != nil {
+			fmt.Printf("Error getting account information : %v\n", err)
+			os.Exit(1)
+		}
+		balance := dollars(account.Balance)
+		if balance >= below {
+			fmt.Printf("Balance %s is not below %s, no top up needed\n", usd(balance), usd(below))
+			return
+		}
+
+		state := topupState{}
+		if err := readState("topup.json", &state); err != nil {
+			fmt.Printf("Error reading top up state : %v\n", err)
+			os.Exit(1)
+		}
+		if len(state.TransactionID) > 0 {
+			transaction, err := client.Transaction.Show(state.TransactionID)
+			if err == nil && transactionState(transaction.Status) == paymentPending {
+				fmt.Printf("Balance %s is below %s, payment %s is still open\n", usd(balance), usd(below), transaction.ID)
+				outputPayment(transaction, qr)
+				return
+			}
+		}
+
+		opts := gobitlaunch.CreateTransactionOptions{
+			AmountUSD:        amount,
+			CryptoSymbol:     coin,
+			LightningNetwork: ln,
+		}
+		if printDryRun("transaction create", "", opts) {
+			return
+		}
+
+		transaction, err := client.Transaction.Create(&opts)
+		if err != nil {
+			fmt.Printf("Error creating a new transaction : %v\n", err)
+			os.Exit(1)
+		}
+		if err := writeState("topup.json", topupState{TransactionID: transaction.ID}); err != nil {
+			fmt.Printf("Error saving top up state : %v\n", err)
+		}
+
+		fmt.Printf("Balance %s is below %s, created payment %s\n", usd(balance), usd(below), transaction.ID)
+		outputPayment(transaction, qr)
+	},
+}
+
+// serverCost is the running cost of a single server
+type serverCost struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Host      string  `json:"host"`
+	Size      string  `json:"size"`
+	Status    string  `json:"status"`
+	Hourly    float64 `json:"hourly"`
+	Daily     float64 `json:"daily"`
+	Monthly   float64 `json:"monthly"`
+	KnownCost bool    `json:"knownCost"`
+}
+
+type serverCosts []serverCost
+
+func (c serverCosts) Headers() []string {
+	return []string{"ID", "NAME", "HOST", "SIZE", "STATUS", "HOURLY", "DAILY", "MONTHLY"}
+}
+
+func (c serverCosts) Rows() [][]string {
+	rows := [][]string{}
+	for _, s := range c {
+		hourly, daily, monthly := fmt.Sprintf("$%.4f", s.Hourly), usd(s.Daily), usd(s.Monthly)
+		if !s.KnownCost {
+			hourly, daily, monthly = "unknown", "unknown", "unknown"
+		}
+		rows = append(rows, []string{s.ID, s.Name, s.Host, s.Size, s.Status, hourly, daily, monthly})
+	}
+	return rows
+}
+
+// forecast is the projected spending of the account
+type forecast struct {
+	Balance        float64     `json:"balance"`
+	HourlyBurn     float64     `json:"hourlyBurn"`
+	DailyBurn      float64     `json:"dailyBurn"`
+	UsagePeriod    string      `json:"usagePeriod"`
+	UsageDailyRate float64     `json:"usageDailyRate"`
+	RunwayDays     float64     `json:"runwayDays"`
+	DepletionDate  *time.Time  `json:"depletionDate,omitempty"`
+	Servers        serverCosts `json:"servers"`
+}
+
+var accountForecast = &cobra.Command{
+	Use:   "forecast",
+	Short: "Forecast how long the account balance will last",
+	Long: `forecast [--format table|json]
+
+Adds up the hourly price of every server on the account to find the daily
+burn and the date the balance runs out, alongside the average daily spend of
+the latest usage period.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+
+		account, err := client.Account.Show()
+		if err != nil {
+			fmt.Printf("Error getting account information : %v\n", err)
+			os.Exit(1)
+		}
+		servers, err := client.Server.List()
+		if err != nil {
+			fmt.Printf("Error listing servers : %v\n", err)
+			os.Exit(1)
+		}
+		usage, err := client.Account.Usage("latest")
+		if err != nil {
+			fmt.Printf("Error getting account usage information : %v\n", err)
+			os.Exit(1)
+		}
+
+		f := forecast{
+			Balance:     dollars(account.Balance),
+			UsagePeriod: usage.Period,
+			Servers:     serverCosts{},
+		}
+		for _, server := range *servers {
+			hourly, ok := serverHourlyCost(server)
+			f.HourlyBurn += hourly
+			f.Servers = append(f.Servers, serverCost{
+				ID:        server.ID,
+				Name:      server.Name,
+				Host:      hostName(server.HostID),
+				Size:      server.Size,
+				Status:    server.Status,
+				Hourly:    hourly,
+				Daily:     hourly * 24,
+				Monthly:   hourly * 24 * 30,
+				KnownCost: ok,
+			})
+		}
+		f.DailyBurn = f.HourlyBurn * 24
+		f.UsageDailyRate = usageDailyRate(usage)
+
+		if f.DailyBurn > 0 {
+			f.RunwayDays = f.Balance / f.DailyBurn
+			if f.RunwayDays < 0 {
+				f.RunwayDays = 0
+			}
+			depletion := time.Now().Add(time.Duration(f.RunwayDays * 24 * float64(time.Hour)))
+			f.DepletionDate = &depletion
+		}
+
+		if format != "table" {
+			printer.OutputAs(format, f)
+			return
+		}
+
+		fmt.Printf("Balance:          %s\n", usd(f.Balance))
+		fmt.Printf("Daily burn:       %s ($%.4f per hour)\n", usd(f.DailyBurn), f.HourlyBurn)
+		fmt.Printf("Usage %-10s  %s per day\n", f.UsagePeriod+":", usd(f.UsageDailyRate))
+		if f.DepletionDate != nil {
+			fmt.Printf("Runway:           %.1f days, until %s\n", f.RunwayDays, f.DepletionDate.Format("2006-01-02"))
+		} else {
+			fmt.Println("Runway:           no running costs")
+		}
+		fmt.Println()
+		printer.OutputAs("table", f.Servers)
+	},
+}
+
+// usageDailyRate is the average daily spend over a usage period, counting
+// only the days that have passed when it is the current month
+func usageDailyRate(usage *gobitlaunch.AccountUsage) float64 {
+	start, err := time.Parse("2006-01", usage.Period)
+	if err != nil {
+		return 0
+	}
+	end := start.AddDate(0, 1, 0)
+	if now := time.Now().UTC(); now.Before(end) {
+		end = now
+	}
+	days := end.Sub(start).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	return dollars(usage.Total) / days
+}
+
+// usageRow is the cost of one server, provider or the whole account in a
+// single period
+type usageRow struct {
+	Period string   `json:"period"`
+	Type   string   `json:"type"`
+	Name   string   `json:"name"`
+	Host   string   `json:"host,omitempty"`
+	Cost   float64  `json:"cost"`
+	Change *float64 `json:"change"`
+}
+
+type usageRows []usageRow
+
+func (r usageRows) Headers() []string {
+	return []string{"PERIOD", "TYPE", "NAME", "HOST", "COST", "CHANGE"}
+}
+
+func (r usageRows) Rows() [][]string {
+	rows := [][]string{}
+	for _, u := range r {
+		change := ""
+		if u.Change != nil {
+			change = usd(*u.Change)
+			if *u.Change >= 0 {
+				change = "+" + change
+			}
+		}
+		rows = append(rows, []string{u.Period, u.Type, u.Name, u.Host, usd(u.Cost), change})
+	}
+	return rows
+}
+
+// usageRange outputs the usage of every period from the first to the last,
+// totalled per period, provider and server
+func usageRange(first, last, format string, concurrency int) {
+	start, err := time.Parse("2006-01", first)
+	if err != nil {
+		fmt.Println("Please specify --from as YYYY-MM")
+		os.Exit(1)
+	}
+	end := time.Now().UTC()
+	if len(last) > 0 {
+		end, err = time.Parse("2006-01", last)
+		if err != nil {
+			fmt.Println("Please specify --to as YYYY-MM")
+			os.Exit(1)
+		}
+	}
+	if end.Before(start) {
+		fmt.Println("--to must not be before --from")
+		os.Exit(1)
+	}
+
+	var periods []string
+	for m := start; !m.After(end); m = m.AddDate(0, 1, 0) {
+		periods = append(periods, m.Format("2006-01"))
+	}
+
+	usages := make([]*gobitlaunch.AccountUsage, len(periods))
+	err = fetchPages(len(periods), 0, concurrency, func(page int) (int, error) {
+		usage, err := client.Account.Usage(periods[page-1])
+		if err != nil {
+			return 0, fmt.Errorf("%s : %v", periods[page-1], err)
+		}
+		usages[page-1] = usage
+		return 0, nil
+	})
+	if err != nil {
+		fmt.Printf("Error getting account usage information for %v\n", err)
+		os.Exit(1)
+	}
+
+	rows := usageRows{}
+	previous := map[string]float64{}
+	var previousOrder []usageRow
+	for i, period := range periods {
+		current := map[string]float64{}
+		var order []usageRow
+		add := func(typ, name, host string, cost float64) {
+			key := typ + "/" + host + "/" + name
+			if _, ok := current[key]; !ok {
+				order = append(order, usageRow{Period: period, Type: typ, Name: name, Host: host})
+			}
+			current[key] += cost
+		}
+
+		add("total", "account", "", dollars(usages[i].Total))
+		for _, s := range usages[i].Servers {
+			add("provider", hostName(s.HostID), "", dollars(s.Cost))
+		}
+		for _, s := range usages[i].Servers {
+			name := s.Name
+			if len(name) == 0 {
+				name = s.ID
+			}
+			add("server", name, hostName(s.HostID), dollars(s.Cost))
+		}
+
+		for _, row := range order {
+			key := row.Type + "/" + row.Host + "/" + row.Name
+			row.Cost = current[key]
+			if i > 0 {
+				change := row.Cost - previous[key]
+				row.Change = &change
+			}
+			rows = append(rows, row)
+		}
+		// servers and providers with no usage this period fall to zero
+		for _, row := range previousOrder {
+			key := row.Type + "/" + row.Host + "/" + row.Name
+			if _, ok := current[key]; ok {
+				continue
+			}
+			change := -previous[key]
+			row.Period = period
+			row.Cost = 0
+			row.Change = &change
+			rows = append(rows, row)
+		}
+		previous = current
+		previousOrder = order
+	}
+
+	printer.OutputAs(format, rows)
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"./printer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit codes of budget check
+const (
+	exitBudgetProjected = 2
+	exitBudgetExceeded  = 3
+)
+
+// Budget sets up the budget command and subcommands
+func Budget() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "budget",
+		Short: "Set and check a monthly spending budget",
+		Long:  `Use the subcommands to set a monthly budget in the config file and check spending against it.`,
+	}
+
+	cmd.AddCommand(budgetSet)
+	cmd.AddCommand(budgetCheck)
+
+	budgetSet.Flags().Float64("monthly", 0, "monthly budget in USD")
+	budgetSet.Flags().IntSlice("thresholds", []int{100}, "percentages of the budget to alert at, comma separated for more than one")
+	budgetSet.Flags().String("webhook", "", "URL to POST a JSON alert to on breach")
+	budgetSet.Flags().String("exec", "", "command to run on breach, with the alert in BL_BUDGET_* environment variables")
+	budgetSet.Flags().String("file", "", "file to append a JSON alert line to on breach")
+	budgetSet.MarkFlagRequired("monthly")
+
+	budgetCheck.Flags().String("webhook", "", "URL to POST a JSON alert to on breach, overriding the config")
+	budgetCheck.Flags().String("exec", "", "command to run on breach, overriding the config")
+	budgetCheck.Flags().String("file", "", "file to append a JSON alert line to on breach, overriding the config")
+
+	return cmd
+}
+
+var budgetSet = &cobra.Command{
+	Use:   "set",
+	Short: "Set the monthly budget",
+	Long:  `set --monthly <usd> [--thresholds 80,100] [--webhook URL] [--exec COMMAND] [--file PATH]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		monthly, _ := cmd.Flags().GetFloat64("monthly")
+		thresholds, _ := cmd.Flags().GetIntSlice("thresholds")
+		if monthly <= 0 {
+			fmt.Println("Please specify a positive --monthly budget")
+			os.Exit(1)
+		}
+
+		viper.Set("budget.monthly", monthly)
+		if cmd.Flags().Changed("thresholds") {
+			viper.Set("budget.thresholds", thresholds)
+		}
+		for _, hook := range []string{"webhook", "exec", "file"} {
+			if cmd.Flags().Changed(hook) {
+				value, _ := cmd.Flags().GetString(hook)
+				viper.Set("budget."+hook, value)
+			}
+		}
+
+		if err := saveConfig(); err != nil {
+			fmt.Printf("Error saving budget : %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Monthly budget set to %s\n", usd(monthly))
+	},
+}
+
+// budgetStatus is the spending of the current month against the budget
+type budgetStatus struct {
+	Period      string  `json:"period"`
+	Budget      float64 `json:"budget"`
+	MonthToDate float64 `json:"monthToDate"`
+	Projected   float64 `json:"projected"`
+	Threshold   int     `json:"threshold,omitempty"`
+	Breach      string  `json:"breach,omitempty"`
+}
+
+var budgetCheck = &cobra.Command{
+	Use:   "check",
+	Short: "Check spending against the monthly budget",
+	Long: `check [--webhook URL] [--exec COMMAND] [--file PATH]
+
+Compares the month to date usage, and the spend projected by the end of the
+month from the servers on the account, with the budget thresholds. On a
+breach the configured hooks are run and check exits with 3 when the month to
+date spend is over a threshold, or 2 when only the projection is.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		monthly := viper.GetFloat64("budget.monthly")
+		if monthly <= 0 {
+			fmt.Println("No budget set, use blcli budget set --monthly <usd>")
+			os.Exit(1)
+		}
+		thresholds := viper.GetIntSlice("budget.thresholds")
+		if len(thresholds) == 0 {
+			thresholds = []int{100}
+		}
+
+		now := time.Now().UTC()
+		period := now.Format("2006-01")
+		usage, err := client.Account.Usage(period)
+		if err != nil {
+			fmt.Printf("Error getting account usage information : %v\n", err)
+			os.Exit(1)
+		}
+		servers, err := client.Server.List()
+		if err != nil {
+			fmt.Printf("Error listing servers : %v\n", err)
+			os.Exit(1)
+		}
+
+		hourly := 0.0
+		for _, server := range *servers {
+			cost, _ := serverHourlyCost(server)
+			hourly += cost
+		}
+		monthEnd := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+		status := budgetStatus{
+			Period:      period,
+			Budget:      monthly,
+			MonthToDate: dollars(usage.Total),
+		}
+		status.Projected = status.MonthToDate + hourly*monthEnd.Sub(now).Hours()
+
+		exceeded, projected := 0, 0
+		for _, t := range thresholds {
+			limit := monthly * float64(t) / 100
+			if status.MonthToDate >= limit && t > exceeded {
+				exceeded = t
+			}
+			if status.Projected >= limit && t > projected {
+				projected = t
+			}
+		}
+		if exceeded > 0 {
+			status.Threshold, status.Breach = exceeded, "exceeded"
+		} else if projected > 0 {
+			status.Threshold, status.Breach = projected, "projected"
+		}
+
+		printer.Output(status)
+		if len(status.Breach) == 0 {
+			return
+		}
+
+		if err := notifyBudget(cmd, status); err != nil {
+			fmt.Printf("Error sending budget alert : %v\n", err)
+		}
+		if status.Breach == "exceeded" {
+			os.Exit(exitBudgetExceeded)
+		}
+		os.Exit(exitBudgetProjected)
+	},
+}
+
+// notifyBudget runs every configured alert hook, flags taking precedence
+// over the config file, and returns the last error
+func notifyBudget(cmd *cobra.Command, status budgetStatus) error {
+	hook := func(name string) string {
+		if cmd.Flags().Changed(name) {
+			value, _ := cmd.Flags().GetString(name)
+			return value
+		}
+		return viper.GetString("budget." + name)
+	}
+
+	alert, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	var last error
+	if url := hook("webhook"); len(url) > 0 {
+		c := &http.Client{Timeout: 30 * time.Second}
+		resp, err := c.Post(url, "application/json", bytes.NewReader(alert))
+		if err != nil {
+			last = err
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				last = fmt.Errorf("webhook returned %s", resp.Status)
+			}
+		}
+	}
+
+	if command := hook("exec"); len(command) > 0 {
+		c := exec.Command("sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			c = exec.Command("cmd", "/C", command)
+		}
+		c.Env = append(os.Environ(),
+			"BL_BUDGET_PERIOD="+status.Period,
+			"BL_BUDGET_MONTHLY="+strconv.FormatFloat(status.Budget, 'f', 2, 64),
+			"BL_BUDGET_MONTH_TO_DATE="+strconv.FormatFloat(status.MonthToDate, 'f', 2, 64),
+			"BL_BUDGET_PROJECTED="+strconv.FormatFloat(status.Projected, 'f', 2, 64),
+			"BL_BUDGET_THRESHOLD="+strconv.Itoa(status.Threshold),
+			"BL_BUDGET_BREACH="+status.Breach,
+			"BL_BUDGET_ALERT="+string(alert),
+		)
+		c.Stdout, c.Stderr = os.Stderr, os.Stderr
+		if err := c.Run(); err != nil {
+			last = err
+		}
+	}
+
+	if path := hook("file"); len(path) > 0 {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			last = err
+		} else {
+			if _, err := f.Write(append(alert, '\n')); err != nil {
+				last = err
+			}
+			f.Close()
+		}
+	}
+
+	return last
+}
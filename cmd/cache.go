@@ -0,0 +1,157 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bitlaunchio/gobitlaunch"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// refresh skips cached data and fetches it from the API again
+var refresh bool
+
+// defaultCacheTTL is how long create options are cached when the config file
+// does not set cache-ttl
+const defaultCacheTTL = 24 * time.Hour
+
+// Cache sets up the cache command and subcommands
+func Cache() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage locally cached data",
+		Long:  `Create options are cached for "cache-ttl" from the config file (default 24h). Use --refresh on any command to bypass the cache.`,
+	}
+
+	cmd.AddCommand(cacheClear)
+
+	return cmd
+}
+
+var cacheClear = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached data",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := cacheRoot()
+		if err != nil {
+			fmt.Printf("Error clearing cache : %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Error clearing cache : %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Cleared cache")
+	},
+}
+
+// cacheTTL returns the cache-ttl setting, or the default when it is not set
+func cacheTTL() time.Duration {
+	if !viper.IsSet("cache-ttl") {
+		return defaultCacheTTL
+	}
+	return viper.GetDuration("cache-ttl")
+}
+
+// cachedCreateOptions returns the create options for a host, from the cache
+// while it is fresh and from the API otherwise
+func cachedCreateOptions(host int) (*gobitlaunch.CreateOptions, error) {
+	name := fmt.Sprintf("create-options-%d.json", host)
+
+	co := &gobitlaunch.CreateOptions{}
+	if readCache(name, cacheTTL(), co) {
+		return co, nil
+	}
+
+	co, err := client.CreateOptions.Show(host)
+	if err != nil {
+		return nil, err
+	}
+	writeCache(name, co)
+	return co, nil
+}
+
+// readCache decodes a cache entry into v if it is younger than ttl
+func readCache(name string, ttl time.Duration, v interface{}) bool {
+	if refresh || ttl <= 0 {
+		return false
+	}
+
+	path, err := cachePath(name)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// writeCache stores v as a cache entry. Failing to cache is not an error
+// for the caller, so it is ignored.
+func writeCache(name string, v interface{}) {
+	path, err := cachePath(name)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	ioutil.WriteFile(path, data, 0600)
+}
+
+// cachePath returns the path of a cache entry for the current account
+func cachePath(name string) (string, error) {
+	dir, err := cacheRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, profile(), name), nil
+}
+
+// profile names the current account after a hash of its API token, so that
+// local data is never shared between accounts
+func profile() string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+func cacheRoot() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "blcli"), nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bitlaunchio/gobitlaunch"
+	"github.com/spf13/cobra"
+)
+
+// completionTTL is how long account data fetched for completions is reused
+const completionTTL = time.Minute
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: "Generate shell completion scripts",
+	Long: `Generate a completion script for your shell. For example:
+
+  bash:       source <(blcli completion bash)
+  zsh:        blcli completion zsh > "${fpath[1]}/_blcli"
+  fish:       blcli completion fish > ~/.config/fish/completions/blcli.fish
+  powershell: blcli completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a shell: bash, zsh, fish or powershell")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletion(os.Stdout)
+		default:
+			err = fmt.Errorf("unsupported shell %q", args[0])
+		}
+		if err != nil {
+			fmt.Printf("Error generating completion : %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// completing reports whether blcli was invoked by a shell to complete a
+// command line, in which case nothing else may be written to stdout
+func completing() bool {
+	return len(os.Args) > 1 &&
+		(os.Args[1] == cobra.ShellCompRequestCmd || os.Args[1] == cobra.ShellCompNoDescRequestCmd)
+}
+
+func completeServers(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	servers := []gobitlaunch.Server{}
+	if !readCache("servers.json", completionTTL, &servers) {
+		list, err := client.Server.List()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		servers = *list
+		writeCache("servers.json", servers)
+	}
+
+	var completions []string
+	for _, s := range servers {
+		completions = append(completions, s.ID+"\t"+s.Name)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeServerArgs completes server IDs for commands taking several servers
+func completeServerArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeServers(cmd, nil, toComplete)
+}
+
+func completeSSHKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	keys := []gobitlaunch.SSHKey{}
+	if !readCache("sshkeys.json", completionTTL, &keys) {
+		list, err := client.SSHKey.List()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		keys = *list
+		writeCache("sshkeys.json", keys)
+	}
+
+	var completions []string
+	for _, k := range keys {
+		completions = append(completions, k.ID+"\t"+k.Name)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeSSHKeyArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeSSHKeys(cmd, args, toComplete)
+}
+
+func completeTransactions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	transactions := []gobitlaunch.Transaction{}
+	if !readCache("transactions.json", completionTTL, &transactions) {
+		list, err := client.Transaction.List(1, 25)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		transactions = *list
+		writeCache("transactions.json", transactions)
+	}
+
+	var completions []string
+	for _, t := range transactions {
+		completions = append(completions, fmt.Sprintf("%s\t%d USD in %s, %s", t.ID, t.AmountUSD, t.Symbol, t.Status))
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePortProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return portProfileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePortProfileArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return portProfileNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeHosts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return hostNames, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeHostArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return hostNames, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeCreateOption returns a flag completion function for the choices of
+// the host given by --host
+func completeCreateOption(choices func(*gobitlaunch.CreateOptions) []choice) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		host, _ := cmd.Flags().GetString("host")
+		hid, err := hostID(host)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return createOptionCompletions(hid, choices)
+	}
+}
+
+// completeServerCreateOption returns a flag completion function for the
+// choices of the host of the server given as the first argument
+func completeServerCreateOption(choices func(*gobitlaunch.CreateOptions) []choice) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) < 1 || client == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		server, err := client.Server.Show(args[0])
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return createOptionCompletions(server.HostID, choices)
+	}
+}
+
+func createOptionCompletions(host int, choices func(*gobitlaunch.CreateOptions) []choice) ([]string, cobra.ShellCompDirective) {
+	if client == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	co, err := cachedCreateOptions(host)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var completions []string
+	for _, c := range choices(co) {
+		completions = append(completions, c.ID+"\t"+c.Description)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
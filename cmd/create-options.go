@@ -17,39 +17,430 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"./printer"
+	"github.com/bitlaunchio/gobitlaunch"
 	"github.com/spf13/cobra"
 )
 
 // CreateOptions sets up the create options command and subcommands
 func CreateOptions() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "create-options <bitlaunch, digitalocean, vultr, or linode>",
-		Short:   "View images, sizes, and options available for a host when creating a new server.",
-		Long:    ``,
-		Aliases: []string{"o"},
-		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) < 1 {
-				return errors.New("please provide a host name: bitlaunch, digitalocean, vultr, or linode")
-			}
-			return nil
-		},
+		Use:               "create-options <bitlaunch, digitalocean, vultr, or linode>",
+		Short:             "View images, sizes, and options available for a host when creating a new server.",
+		Long:              ``,
+		Aliases:           []string{"o"},
+		Args:              hostArg,
+		ValidArgsFunction: completeHostArg,
 		Run: func(cmd *cobra.Command, args []string) {
-			id := args[0]
-			hid, err := hostID(id)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			printer.Output(hostCreateOptions(args[0]))
+		},
+	}
+
+	cmd.AddCommand(createOptionsCompare)
+	cmd.AddCommand(createOptionsImages)
+	cmd.AddCommand(createOptionsSizes)
+	cmd.AddCommand(createOptionsRegions)
+
+	createOptionsCompare.Flags().Int("cpu", 0, "minimum number of CPUs")
+	createOptionsCompare.Flags().String("memory", "", "minimum memory, e.g. 512M or 4G")
+	createOptionsCompare.Flags().String("disk", "", "minimum disk size, e.g. 50G")
+	createOptionsCompare.Flags().String("region-like", "", "only include hosts with a region matching this name")
+	createOptionsCompare.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	createOptionsImages.Flags().String("family", "", "only show images of this family or type, e.g. ubuntu or app")
+	createOptionsImages.Flags().String("region", "", "only show images available in this region id")
+	createOptionsImages.Flags().String("search", "", "only show images matching these words")
+	createOptionsImages.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	createOptionsSizes.Flags().String("family", "", "only show sizes of this plan type")
+	createOptionsSizes.Flags().String("min-memory", "", "minimum memory, e.g. 512M or 4G")
+	createOptionsSizes.Flags().Float64("max-price", 0, "maximum monthly price in USD")
+	createOptionsSizes.Flags().String("search", "", "only show sizes matching these words")
+	createOptionsSizes.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	createOptionsRegions.Flags().String("search", "", "only show regions matching these words")
+	createOptionsRegions.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	return cmd
+}
+
+// hostCreateOptions gets the create options for a host name, exiting on error
+func hostCreateOptions(name string) *gobitlaunch.CreateOptions {
+	hid, err := hostID(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	co, err := cachedCreateOptions(hid)
+	if err != nil {
+		fmt.Printf("Error getting create options : %v\n", err)
+		os.Exit(1)
+	}
+	return co
+}
+
+func hostArg(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("please provide a host name: bitlaunch, digitalocean, vultr, or linode")
+	}
+	return nil
+}
+
+// matchesSearch reports whether search is empty or matches any of fields
+func matchesSearch(search string, fields ...string) bool {
+	if len(search) == 0 {
+		return true
+	}
+	text := strings.ToLower(strings.Join(fields, " "))
+	search = strings.ToLower(search)
+	return strings.Contains(text, search) || prefixesWords(text, search)
+}
+
+// imageRow is a single image version from a host's create options
+type imageRow struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Type        string `json:"type"`
+	MinDiskSize int    `json:"minDiskSize"`
+}
+
+type imageRows []imageRow
+
+func (r imageRows) Headers() []string {
+	return []string{"ID", "NAME", "VERSION", "TYPE", "MIN DISK"}
+}
+
+func (r imageRows) Rows() [][]string {
+	rows := [][]string{}
+	for _, image := range r {
+		rows = append(rows, []string{image.ID, image.Name, image.Version, image.Type, fmt.Sprintf("%dGB", image.MinDiskSize)})
+	}
+	return rows
+}
+
+var createOptionsImages = &cobra.Command{
+	Use:     "images",
+	Short:   "List the images available for a host",
+	Long:    `images <host> [--family ubuntu] [--region lon1] [--search "22.04"]`,
+	Aliases: []string{"image", "i"},
+	Args:    hostArg,
+	Run: func(cmd *cobra.Command, args []string) {
+		family, _ := cmd.Flags().GetString("family")
+		region, _ := cmd.Flags().GetString("region")
+		search, _ := cmd.Flags().GetString("search")
+		format, _ := cmd.Flags().GetString("format")
+
+		co := hostCreateOptions(args[0])
+
+		rows := imageRows{}
+		for _, image := range co.Image {
+			if len(family) > 0 && !matchesSearch(family, image.Name, image.Type) {
+				continue
 			}
-			server, err := client.CreateOptions.Show(hid)
-			if err != nil {
-				fmt.Printf("Error getting server : %v\n", err)
-				os.Exit(1)
+			if len(region) > 0 && contains(image.UnavailableRegions, region) {
+				continue
 			}
 
-			printer.Output(server)
-		},
+			versions := image.Versions
+			if len(versions) == 0 {
+				versions = []gobitlaunch.ImageVersion{{ID: image.ID}}
+			}
+			for _, version := range versions {
+				if !matchesSearch(search, version.ID, image.Name, version.Description) {
+					continue
+				}
+				rows = append(rows, imageRow{
+					ID:          version.ID,
+					Name:        image.Name,
+					Version:     version.Description,
+					Type:        image.Type,
+					MinDiskSize: image.MinDiskSize,
+				})
+			}
+		}
+
+		printer.OutputAs(format, rows)
+	},
+}
+
+// sizeRow is a single size from a host's create options
+type sizeRow struct {
+	ID           string  `json:"id"`
+	PlanType     string  `json:"planType"`
+	CPUCount     int     `json:"cpuCount"`
+	MemoryMB     int     `json:"memoryMB"`
+	DiskGB       int     `json:"diskGB"`
+	BandwidthGB  int     `json:"bandwidthGB"`
+	CostPerHr    float64 `json:"costPerHr"`
+	CostPerMonth float64 `json:"costPerMonth"`
+}
+
+type sizeRows []sizeRow
+
+func (r sizeRows) Headers() []string {
+	return []string{"ID", "PLAN", "CPU", "MEMORY", "DISK", "BANDWIDTH", "HOURLY", "MONTHLY"}
+}
+
+func (r sizeRows) Rows() [][]string {
+	rows := [][]string{}
+	for _, size := range r {
+		rows = append(rows, []string{
+			size.ID,
+			size.PlanType,
+			strconv.Itoa(size.CPUCount),
+			memoryString(size.MemoryMB),
+			fmt.Sprintf("%dGB", size.DiskGB),
+			fmt.Sprintf("%dGB", size.BandwidthGB),
+			fmt.Sprintf("$%.4f", size.CostPerHr),
+			usd(size.CostPerMonth),
+		})
 	}
-	return cmd
+	return rows
+}
+
+var createOptionsSizes = &cobra.Command{
+	Use:     "sizes",
+	Short:   "List the sizes available for a host",
+	Long:    `sizes <host> [--family standard] [--min-memory 2G] [--max-price 20] [--search nibble]`,
+	Aliases: []string{"size", "s"},
+	Args:    hostArg,
+	Run: func(cmd *cobra.Command, args []string) {
+		family, _ := cmd.Flags().GetString("family")
+		minMemory, _ := cmd.Flags().GetString("min-memory")
+		maxPrice, _ := cmd.Flags().GetFloat64("max-price")
+		search, _ := cmd.Flags().GetString("search")
+		format, _ := cmd.Flags().GetString("format")
+
+		memoryMB, err := parseMegabytes(minMemory)
+		if err != nil {
+			fmt.Printf("Invalid memory : %v\n", err)
+			os.Exit(1)
+		}
+
+		co := hostCreateOptions(args[0])
+
+		rows := sizeRows{}
+		for _, size := range co.Size {
+			if len(family) > 0 && !strings.EqualFold(size.PlanType, family) {
+				continue
+			}
+			if size.MemoryMB < memoryMB || (maxPrice > 0 && size.CostPerMonth > maxPrice) {
+				continue
+			}
+			if !matchesSearch(search, size.ID, size.Slug, size.PlanType) {
+				continue
+			}
+			rows = append(rows, sizeRow{
+				ID:           size.ID,
+				PlanType:     size.PlanType,
+				CPUCount:     size.CPUCount,
+				MemoryMB:     size.MemoryMB,
+				DiskGB:       size.DiskGB,
+				BandwidthGB:  size.BandwidthGB,
+				CostPerHr:    dollars(size.CostPerHr),
+				CostPerMonth: size.CostPerMonth,
+			})
+		}
+
+		printer.OutputAs(format, rows)
+	},
+}
+
+// regionRow is a single region from a host's create options
+type regionRow struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type regionRows []regionRow
+
+func (r regionRows) Headers() []string {
+	return []string{"ID", "NAME", "DESCRIPTION"}
+}
+
+func (r regionRows) Rows() [][]string {
+	rows := [][]string{}
+	for _, region := range r {
+		rows = append(rows, []string{region.ID, region.Name, region.Description})
+	}
+	return rows
+}
+
+var createOptionsRegions = &cobra.Command{
+	Use:     "regions",
+	Short:   "List the regions available for a host",
+	Long:    `regions <host> [--search london]`,
+	Aliases: []string{"region", "r"},
+	Args:    hostArg,
+	Run: func(cmd *cobra.Command, args []string) {
+		search, _ := cmd.Flags().GetString("search")
+		format, _ := cmd.Flags().GetString("format")
+
+		co := hostCreateOptions(args[0])
+
+		rows := regionRows{}
+		for _, region := range co.Region {
+			subs := region.SubRegions
+			if len(subs) == 0 {
+				subs = []gobitlaunch.SubRegion{{ID: region.ID}}
+			}
+			for _, sub := range subs {
+				if !matchesSearch(search, sub.ID, sub.Slug, region.Name, sub.Description) {
+					continue
+				}
+				rows = append(rows, regionRow{ID: sub.ID, Name: region.Name, Description: sub.Description})
+			}
+		}
+
+		printer.OutputAs(format, rows)
+	},
+}
+
+// sizeOffer is a size from one host that matches a compare query
+type sizeOffer struct {
+	Host         string  `json:"host"`
+	SizeID       string  `json:"sizeId"`
+	CPUCount     int     `json:"cpuCount"`
+	MemoryMB     int     `json:"memoryMB"`
+	DiskGB       int     `json:"diskGB"`
+	Region       string  `json:"region,omitempty"`
+	CostPerHr    float64 `json:"costPerHr"`
+	CostPerMonth float64 `json:"costPerMonth"`
+}
+
+type sizeOffers []sizeOffer
+
+func (o sizeOffers) Headers() []string {
+	return []string{"HOST", "SIZE", "CPU", "MEMORY", "DISK", "REGION", "HOURLY", "MONTHLY"}
+}
+
+func (o sizeOffers) Rows() [][]string {
+	rows := [][]string{}
+	for _, offer := range o {
+		rows = append(rows, []string{
+			offer.Host,
+			offer.SizeID,
+			strconv.Itoa(offer.CPUCount),
+			memoryString(offer.MemoryMB),
+			fmt.Sprintf("%dGB", offer.DiskGB),
+			offer.Region,
+			fmt.Sprintf("$%.4f", offer.CostPerHr),
+			usd(offer.CostPerMonth),
+		})
+	}
+	return rows
+}
+
+var createOptionsCompare = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare the price of a server spec across all hosts",
+	Long:  `compare --cpu 2 --memory 4G --disk 50G [--region-like london]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cpu, _ := cmd.Flags().GetInt("cpu")
+		memory, _ := cmd.Flags().GetString("memory")
+		disk, _ := cmd.Flags().GetString("disk")
+		regionLike, _ := cmd.Flags().GetString("region-like")
+		format, _ := cmd.Flags().GetString("format")
+
+		memoryMB, err := parseMegabytes(memory)
+		if err != nil {
+			fmt.Printf("Invalid memory : %v\n", err)
+			os.Exit(1)
+		}
+		diskMB, err := parseMegabytes(disk)
+		if err != nil {
+			fmt.Printf("Invalid disk : %v\n", err)
+			os.Exit(1)
+		}
+
+		results := make([]*gobitlaunch.CreateOptions, len(hostNames))
+		errs := make([]error, len(hostNames))
+		var wg sync.WaitGroup
+		for i, name := range hostNames {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				hid, _ := hostID(name)
+				results[i], errs[i] = cachedCreateOptions(hid)
+			}(i, name)
+		}
+		wg.Wait()
+
+		offers := sizeOffers{}
+		for i, name := range hostNames {
+			if errs[i] != nil {
+				fmt.Fprintf(os.Stderr, "Error getting create options for %s : %v\n", name, errs[i])
+				continue
+			}
+
+			region := ""
+			if len(regionLike) > 0 {
+				matches := suggest(regionLike, regionChoices(results[i]), 1)
+				if len(matches) == 0 || fuzzyScore(regionLike, matches[0]) > 0 {
+					continue
+				}
+				region = matches[0].ID
+			}
+
+			for _, size := range results[i].Size {
+				if size.CPUCount < cpu || size.MemoryMB < memoryMB || size.DiskGB*1024 < diskMB {
+					continue
+				}
+				offers = append(offers, sizeOffer{
+					Host:         name,
+					SizeID:       size.ID,
+					CPUCount:     size.CPUCount,
+					MemoryMB:     size.MemoryMB,
+					DiskGB:       size.DiskGB,
+					Region:       region,
+					CostPerHr:    dollars(size.CostPerHr),
+					CostPerMonth: size.CostPerMonth,
+				})
+			}
+		}
+
+		sort.SliceStable(offers, func(i, j int) bool {
+			if offers[i].CostPerHr != offers[j].CostPerHr {
+				return offers[i].CostPerHr < offers[j].CostPerHr
+			}
+			return offers[i].CostPerMonth < offers[j].CostPerMonth
+		})
+
+		printer.OutputAs(format, offers)
+	},
+}
+
+// parseMegabytes reads a size such as 512M, 4G, 4GB or 1T as megabytes.
+// A plain number is taken to be gigabytes and an empty size is zero.
+func parseMegabytes(size string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if len(s) == 0 {
+		return 0, nil
+	}
+
+	s = strings.TrimSuffix(s, "B")
+	unit := 1024
+	switch {
+	case strings.HasSuffix(s, "M"):
+		unit = 1
+		s = strings.TrimSuffix(s, "M")
+	case strings.HasSuffix(s, "G"):
+		s = strings.TrimSuffix(s, "G")
+	case strings.HasSuffix(s, "T"):
+		unit = 1024 * 1024
+		s = strings.TrimSuffix(s, "T")
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid size", size)
+	}
+	return int(n * float64(unit)), nil
 }
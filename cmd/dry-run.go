@@ -0,0 +1,40 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"./printer"
+)
+
+// dryRunRequest describes an API request that would have been sent
+type dryRunRequest struct {
+	Action  string      `json:"action"`
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// printDryRun outputs the request a mutating command would send when
+// --dry-run is set, and reports whether the command should stop there
+func printDryRun(action, id string, payload interface{}) bool {
+	if !dryRun {
+		return false
+	}
+
+	printer.Output(dryRunRequest{
+		Action:  action,
+		ID:      id,
+		Payload: payload,
+	})
+	return true
+}
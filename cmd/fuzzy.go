@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// fuzzyScore rates how closely value matches a choice, lower is better.
+// A value contained in the id, or whose words all start words of the
+// description, scores 0. Otherwise the score is the smallest edit distance
+// to the id, an alias or a description word.
+func fuzzyScore(value string, c choice) int {
+	v := strings.ToLower(value)
+	desc := strings.ToLower(c.Description)
+
+	keys := append([]string{c.ID}, c.Aliases...)
+	for _, key := range keys {
+		if strings.Contains(strings.ToLower(key), v) {
+			return 0
+		}
+	}
+	if prefixesWords(desc, v) {
+		return 0
+	}
+
+	best := levenshtein(v, desc)
+	for _, key := range keys {
+		if d := levenshtein(v, strings.ToLower(key)); d < best {
+			best = d
+		}
+	}
+	for _, word := range words(desc) {
+		if d := levenshtein(v, word); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// suggest returns up to limit choices that are close to value, best first
+func suggest(value string, choices []choice, limit int) []choice {
+	type scored struct {
+		choice
+		score int
+	}
+
+	max := len(value)/3 + 1
+	var matches []scored
+	for _, c := range choices {
+		if s := fuzzyScore(value, c); s <= max {
+			matches = append(matches, scored{c, s})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+
+	var out []choice
+	for i := 0; i < len(matches) && i < limit; i++ {
+		out = append(out, matches[i].choice)
+	}
+	return out
+}
+
+// prefixesWords reports whether every word of value is the start of a word
+// in s, so "ubuntu 22" matches "Ubuntu 22.04 LTS" but "2gb" misses "32GB"
+func prefixesWords(s, value string) bool {
+	want := words(value)
+	if len(want) == 0 {
+		return false
+	}
+
+	have := words(s)
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if strings.HasPrefix(h, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// words splits s on anything other than letters, digits and dots
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.'
+	})
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bitlaunchio/gobitlaunch"
+)
+
+// ledgerEntry is a payment normalized for bookkeeping
+type ledgerEntry struct {
+	Date          time.Time `json:"date"`
+	ID            string    `json:"id"`
+	TransactionID string    `json:"transactionId"`
+	Status        string    `json:"status"`
+	Coin          string    `json:"coin"`
+	AmountCrypto  string    `json:"amountCrypto"`
+	AmountUSD     int       `json:"amountUsd"`
+	Address       string    `json:"address"`
+}
+
+func newLedgerEntry(t gobitlaunch.Transaction, loc *time.Location) ledgerEntry {
+	return ledgerEntry{
+		Date:          t.Date.In(loc),
+		ID:            t.ID,
+		TransactionID: t.TransactionID,
+		Status:        t.Status,
+		Coin:          t.Symbol,
+		AmountCrypto:  t.AmountCrypto,
+		AmountUSD:     t.AmountUSD,
+		Address:       t.Address,
+	}
+}
+
+// memo describes an entry in one line for formats with a free text field
+func (e ledgerEntry) memo() string {
+	return fmt.Sprintf("%s %s, %s", e.AmountCrypto, e.Coin, e.ID)
+}
+
+type ledgerEntries []ledgerEntry
+
+func (l ledgerEntries) Headers() []string {
+	return []string{"date", "id", "transaction_id", "status", "coin", "amount_crypto", "amount_usd", "address"}
+}
+
+func (l ledgerEntries) Rows() [][]string {
+	rows := [][]string{}
+	for _, e := range l {
+		rows = append(rows, []string{
+			e.Date.Format(time.RFC3339),
+			e.ID,
+			e.TransactionID,
+			e.Status,
+			e.Coin,
+			e.AmountCrypto,
+			fmt.Sprintf("%d.00", e.AmountUSD),
+			e.Address,
+		})
+	}
+	return rows
+}
+
+// writeQIF writes entries as Quicken Interchange Format bank deposits
+func writeQIF(w io.Writer, entries ledgerEntries) {
+	fmt.Fprintln(w, "!Type:Bank")
+	for _, e := range entries {
+		fmt.Fprintf(w, "D%s\n", e.Date.Format("01/02/2006"))
+		fmt.Fprintf(w, "T%d.00\n", e.AmountUSD)
+		fmt.Fprintln(w, "PBitLaunch")
+		fmt.Fprintf(w, "M%s\n", e.memo())
+		fmt.Fprintf(w, "N%s\n", e.ID)
+		fmt.Fprintln(w, "^")
+	}
+}
+
+// writeOFX writes entries, oldest first, as an OFX 2 bank statement of
+// credits in USD, with their total as the ledger balance
+func writeOFX(w io.Writer, entries ledgerEntries, from, to time.Time) {
+	const layout = "20060102150405"
+	if from.IsZero() && len(entries) > 0 {
+		from = entries[0].Date
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	total := 0
+	for _, e := range entries {
+		total += e.AmountUSD
+	}
+
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`)
+	fmt.Fprintln(w, "<OFX>")
+	fmt.Fprintln(w, "<SIGNONMSGSRSV1><SONRS>")
+	fmt.Fprintln(w, "<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	fmt.Fprintf(w, "<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE>\n", time.Now().In(to.Location()).Format(layout))
+	fmt.Fprintln(w, "</SONRS></SIGNONMSGSRSV1>")
+	fmt.Fprintln(w, "<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID>")
+	fmt.Fprintln(w, "<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	fmt.Fprintln(w, "<STMTRS><CURDEF>USD</CURDEF>")
+	fmt.Fprintln(w, "<BANKACCTFROM><BANKID>BITLAUNCH</BANKID><ACCTID>BITLAUNCH</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>")
+	fmt.Fprintf(w, "<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", from.Format(layout), to.Format(layout))
+	for _, e := range entries {
+		fmt.Fprintln(w, "<STMTTRN>")
+		fmt.Fprintln(w, "<TRNTYPE>CREDIT</TRNTYPE>")
+		fmt.Fprintf(w, "<DTPOSTED>%s</DTPOSTED>\n", e.Date.Format(layout))
+		fmt.Fprintf(w, "<TRNAMT>%d.00</TRNAMT>\n", e.AmountUSD)
+		fmt.Fprintf(w, "<FITID>%s</FITID>\n", html.EscapeString(e.ID))
+		fmt.Fprintln(w, "<NAME>BitLaunch</NAME>")
+		fmt.Fprintf(w, "<MEMO>%s</MEMO>\n", html.EscapeString(e.memo()))
+		fmt.Fprintln(w, "</STMTTRN>")
+	}
+	fmt.Fprintln(w, "</BANKTRANLIST>")
+	fmt.Fprintf(w, "<LEDGERBAL><BALAMT>%d.00</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n", total, to.Format(layout))
+	fmt.Fprintln(w, "</STMTRS></STMTTRNRS></BANKMSGSRSV1>")
+	fmt.Fprintln(w, "</OFX>")
+}
+
+// writeLedger writes entries as ledger-cli transactions that move crypto
+// from the source account into the account balance at its USD price
+func writeLedger(w io.Writer, entries ledgerEntries, account, source string) {
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		mark := "*"
+		if transactionState(e.Status) != paymentConfirmed {
+			mark = "!"
+		}
+		fmt.Fprintf(w, "%s %s BitLaunch top up  ; id: %s\n", e.Date.Format("2006/01/02"), mark, e.ID)
+		fmt.Fprintf(w, "    %-36s $%d.00\n", account, e.AmountUSD)
+		fmt.Fprintf(w, "    %-36s -%s %s @@ $%d.00\n", source+":"+strings.ToUpper(e.Coin), e.AmountCrypto, strings.ToUpper(e.Coin), e.AmountUSD)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bitlaunchio/gobitlaunch"
+)
+
+// millsPerDollar converts the API's balances and costs, which are given in
+// thousandths of a US dollar
+const millsPerDollar = 1000
+
+// dollars converts an API amount to US dollars
+func dollars(mills int) float64 {
+	return float64(mills) / millsPerDollar
+}
+
+// usd formats an amount in US dollars
+func usd(amount float64) string {
+	if amount < 0 {
+		return fmt.Sprintf("-$%.2f", -amount)
+	}
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// serverHourlyCost looks up the hourly price in US dollars of a server's size
+// in the create options of its host
+func serverHourlyCost(server gobitlaunch.Server) (float64, bool) {
+	co, err := cachedCreateOptions(server.HostID)
+	if err != nil {
+		return 0, false
+	}
+	for _, size := range co.Size {
+		if size.ID == server.Size || size.Slug == server.Size {
+			return dollars(size.CostPerHr), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fetchPages calls fetch for every page from 1, up to concurrency pages at a
+// time, reporting progress on stderr. When pages is known exactly that many
+// are fetched, otherwise it stops after a page with fewer than perPage items,
+// or with a perPage of 0 after an empty page.
+func fetchPages(pages, perPage, concurrency int, fetch func(page int) (int, error)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	defer fmt.Fprint(os.Stderr, clearLine)
+
+	for start := 1; pages == 0 || start <= pages; start += concurrency {
+		batch := concurrency
+		if pages > 0 && start+batch-1 > pages {
+			batch = pages - start + 1
+		}
+
+		counts := make([]int, batch)
+		errs := make([]error, batch)
+		var wg sync.WaitGroup
+		for i := 0; i < batch; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				counts[i], errs[i] = fetch(start + i)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+
+		fetched := start + batch - 1
+		if pages > 0 {
+			fmt.Fprintf(os.Stderr, "%sFetched page %d of %d", clearLine, fetched, pages)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sFetched page %d", clearLine, fetched)
+		}
+
+		if pages == 0 {
+			for _, n := range counts {
+				if n == 0 || n < perPage {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dateRange holds the --since and --until filters of a listing
+type dateRange struct {
+	since time.Time
+	until time.Time
+}
+
+// parseDateRange reads dates as YYYY-MM-DD in loc or RFC 3339. An --until
+// date without a time includes the whole of that day.
+func parseDateRange(since, until string, loc *time.Location) (dateRange, error) {
+	var r dateRange
+	var err error
+	if len(since) > 0 {
+		if r.since, err = parseDate(since, loc); err != nil {
+			return r, fmt.Errorf("invalid --since date %q", since)
+		}
+	}
+	if len(until) > 0 {
+		if r.until, err = parseDate(until, loc); err != nil {
+			return r, fmt.Errorf("invalid --until date %q", until)
+		}
+		if len(until) == len("2006-01-02") {
+			r.until = r.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	return r, nil
+}
+
+func parseDate(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// includes reports whether t falls inside the range
+func (r dateRange) includes(t time.Time) bool {
+	if !r.since.IsZero() && t.Before(r.since) {
+		return false
+	}
+	if !r.until.IsZero() && t.After(r.until) {
+		return false
+	}
+	return true
+}
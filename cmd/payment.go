@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/bitlaunchio/gobitlaunch"
+	"github.com/mdp/qrterminal"
+)
+
+// paymentURI builds the URI a wallet scans to pay a transaction, using the
+// scheme of its currency: BIP21 for bitcoin and its forks, EIP-681 for ether,
+// the nano and tron schemes, and BOLT11 invoices for the lightning network.
+func paymentURI(t *gobitlaunch.Transaction) (string, error) {
+	if len(t.Address) == 0 {
+		return "", errors.New("transaction has no payment address")
+	}
+	if invoice, ok := lightningInvoice(t.Address); ok {
+		return "lightning:" + invoice, nil
+	}
+	if len(t.AmountCrypto) == 0 {
+		return "", errors.New("transaction has no crypto amount")
+	}
+
+	switch strings.ToUpper(t.Symbol) {
+	case "BTC":
+		return fmt.Sprintf("bitcoin:%s?amount=%s", t.Address, t.AmountCrypto), nil
+	case "LTC":
+		return fmt.Sprintf("litecoin:%s?amount=%s", t.Address, t.AmountCrypto), nil
+	case "BCH":
+		address := strings.TrimPrefix(t.Address, "bitcoincash:")
+		return fmt.Sprintf("bitcoincash:%s?amount=%s", address, t.AmountCrypto), nil
+	case "ETH":
+		wei, err := scaleAmount(t.AmountCrypto, 18)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("ethereum:%s?value=%s", t.Address, wei), nil
+	case "NANO":
+		raw, err := scaleAmount(t.AmountCrypto, 30)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("nano:%s?amount=%s", t.Address, raw), nil
+	case "TRX":
+		return fmt.Sprintf("tron:%s?amount=%s", t.Address, t.AmountCrypto), nil
+	default:
+		return "", fmt.Errorf("no payment URI scheme for %s, pay %s %s to %s", t.Symbol, t.AmountCrypto, t.Symbol, t.Address)
+	}
+}
+
+// lightningInvoice returns the BOLT11 invoice if address is one
+func lightningInvoice(address string) (string, bool) {
+	invoice := strings.TrimPrefix(strings.ToLower(address), "lightning:")
+	for _, prefix := range []string{"lnbc", "lntb", "lnltc", "lntltc"} {
+		if strings.HasPrefix(invoice, prefix) {
+			return invoice, true
+		}
+	}
+	return "", false
+}
+
+// scaleAmount converts a decimal amount to an integer count of its smallest
+// unit, such as ether to wei, rounding up so the full amount is paid
+func scaleAmount(amount string, decimals int64) (string, error) {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return "", fmt.Errorf("invalid amount %q", amount)
+	}
+
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil)
+	r.Mul(r, new(big.Rat).SetInt(unit))
+
+	n, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() > 0 {
+		n.Add(n, big.NewInt(1))
+	}
+	return n.String(), nil
+}
+
+// printQRCode writes the payment URI of a transaction as a QR code to the
+// terminal, exiting if it cannot be paid by scanning
+func printQRCode(t *gobitlaunch.Transaction) {
+	uri, err := paymentURI(t)
+	if err != nil {
+		fmt.Printf("Unable to generate a QR Code for this transaction : %v\n", err)
+		os.Exit(1)
+	}
+
+	qrterminal.Generate(uri, qrterminal.L, os.Stdout)
+}
+
+// outputPayment prints the payment URI of a transaction, falling back to its
+// address when there is no URI scheme for its currency, and optionally a QR
+// code of the URI
+func outputPayment(t *gobitlaunch.Transaction, qr bool) {
+	uri, err := paymentURI(t)
+	if err != nil {
+		fmt.Printf("Pay %s %s to %s\n", t.AmountCrypto, t.Symbol, t.Address)
+		return
+	}
+
+	fmt.Println(uri)
+	if qr {
+		qrterminal.Generate(uri, qrterminal.L, os.Stdout)
+	}
+}
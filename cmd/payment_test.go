@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/bitlaunchio/gobitlaunch"
+)
+
+func TestPaymentURI(t *testing.T) {
+	tests := []struct {
+		symbol  string
+		address string
+		amount  string
+		want    string
+		error   bool
+	}{
+		{symbol: "BTC", address: "bc1qaddr", amount: "0.00123", want: "bitcoin:bc1qaddr?amount=0.00123"},
+		{symbol: "btc", address: "bc1qaddr", amount: "0.00123", want: "bitcoin:bc1qaddr?amount=0.00123"},
+		{symbol: "LTC", address: "ltc1qaddr", amount: "0.25", want: "litecoin:ltc1qaddr?amount=0.25"},
+		{symbol: "BCH", address: "qpaddr", amount: "0.1", want: "bitcoincash:qpaddr?amount=0.1"},
+		{symbol: "BCH", address: "bitcoincash:qpaddr", amount: "0.1", want: "bitcoincash:qpaddr?amount=0.1"},
+		{symbol: "ETH", address: "0xaddr", amount: "0.05", want: "ethereum:0xaddr?value=50000000000000000"},
+		{symbol: "ETH", address: "0xaddr", amount: "1", want: "ethereum:0xaddr?value=1000000000000000000"},
+		{symbol: "ETH", address: "0xaddr", amount: "0.0000000000000000011", want: "ethereum:0xaddr?value=2"},
+		{symbol: "NANO", address: "nano_addr", amount: "1.5", want: "nano:nano_addr?amount=1500000000000000000000000000000"},
+		{symbol: "NANO", address: "nano_addr", amount: "0.000000000000000000000000000001", want: "nano:nano_addr?amount=1"},
+		{symbol: "TRX", address: "Taddr", amount: "12.5", want: "tron:Taddr?amount=12.5"},
+		{symbol: "BTC", address: "LNBC10U1PWJQWKK", amount: "0.00001", want: "lightning:lnbc10u1pwjqwkk"},
+		{symbol: "BTC", address: "lightning:lnbc10u1pwjqwkk", want: "lightning:lnbc10u1pwjqwkk"},
+		{symbol: "LTC", address: "lnltc10u1pwjqwkk", want: "lightning:lnltc10u1pwjqwkk"},
+		{symbol: "SRN", address: "0xaddr", amount: "10", error: true},
+		{symbol: "ETH", address: "0xaddr", amount: "abc", error: true},
+		{symbol: "NANO", address: "nano_addr", amount: "", error: true},
+		{symbol: "BTC", address: "", amount: "0.1", error: true},
+	}
+
+	for _, test := range tests {
+		uri, err := paymentURI(&gobitlaunch.Transaction{Symbol: test.symbol, Address: test.address, AmountCrypto: test.amount})
+		if test.error {
+			if err == nil {
+				t.Errorf("paymentURI(%s %q %q) = %s, want an error", test.symbol, test.address, test.amount, uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("paymentURI(%s %q %q) returned error: %v", test.symbol, test.address, test.amount, err)
+			continue
+		}
+		if uri != test.want {
+			t.Errorf("paymentURI(%s %q %q) = %s, want %s", test.symbol, test.address, test.amount, uri, test.want)
+		}
+	}
+}
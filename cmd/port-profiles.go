@@ -0,0 +1,342 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"./printer"
+	"github.com/bitlaunchio/gobitlaunch"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit code of ports audit when a server has drifted from its profile
+const exitPortsDrift = 2
+
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Ports sets up the ports command and subcommands
+func Ports() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ports",
+		Short: "Manage port profiles for protected servers",
+		Long:  `Use the subcommands to keep named port sets in the config file and check servers against them.`,
+	}
+
+	profile := &cobra.Command{
+		Use:     "profile",
+		Short:   "Create, list or delete port profiles",
+		Long:    `Use the subcommands to create, list or delete port profiles.`,
+		Aliases: []string{"profiles"},
+	}
+	profile.AddCommand(portsProfileCreate)
+	profile.AddCommand(portsProfileList)
+	profile.AddCommand(portsProfileDelete)
+
+	cmd.AddCommand(profile)
+	cmd.AddCommand(portsAudit)
+
+	portsProfileCreate.Flags().StringP("ports", "p", "", "port[-port][:tcp|udp|both] or a preset (ssh, web, wireguard), comma separated for more than one")
+	portsProfileCreate.MarkFlagRequired("ports")
+
+	portsProfileList.Flags().StringP("format", "f", "table", "output format: table or json")
+	portsAudit.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	return cmd
+}
+
+var portsProfileCreate = &cobra.Command{
+	Use:   "create",
+	Short: "Create or replace a port profile",
+	Long:  `create <name> --ports 80:tcp,443:tcp`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a profile name")
+		}
+		if !profileName.MatchString(args[0]) {
+			return errors.New("profile names may only contain lowercase letters, digits, - and _")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		ports, _ := cmd.Flags().GetString("ports")
+
+		if _, err := parsePorts(ports); err != nil {
+			fmt.Printf("Error creating port profile : %v\n", err)
+			os.Exit(1)
+		}
+
+		profiles := portProfiles()
+		profiles[name] = strings.Join(strings.Fields(strings.ToLower(ports)), "")
+		viper.Set("ports.profiles", profiles)
+		if err := saveConfig(); err != nil {
+			fmt.Printf("Error saving port profile : %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Port profile %s saved\n", name)
+	},
+}
+
+// portProfile is a port profile and the servers it was applied to
+type portProfile struct {
+	Name    string   `json:"name"`
+	Ports   string   `json:"ports"`
+	Servers []string `json:"servers"`
+}
+
+type portProfileList []portProfile
+
+func (l portProfileList) Headers() []string {
+	return []string{"NAME", "PORTS", "SERVERS"}
+}
+
+func (l portProfileList) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range l {
+		rows = append(rows, []string{p.Name, p.Ports, strconv.Itoa(len(p.Servers))})
+	}
+	return rows
+}
+
+var portsProfileList = &cobra.Command{
+	Use:     "list",
+	Short:   "List port profiles",
+	Long:    `list [--format table|json]`,
+	Aliases: []string{"ls"},
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+
+		profiles := portProfiles()
+		assigned := portProfileServers()
+		list := portProfileList{}
+		for _, name := range portProfileNames() {
+			p := portProfile{Name: name, Ports: profiles[name], Servers: []string{}}
+			for id, profile := range assigned {
+				if profile == name {
+					p.Servers = append(p.Servers, id)
+				}
+			}
+			sort.Strings(p.Servers)
+			list = append(list, p)
+		}
+
+		printer.OutputAs(format, list)
+	},
+}
+
+var portsProfileDelete = &cobra.Command{
+	Use:               "delete",
+	Short:             "Delete a port profile",
+	Long:              `delete <name>`,
+	Aliases:           []string{"rm"},
+	ValidArgsFunction: completePortProfileArg,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a profile name")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+
+		profiles := portProfiles()
+		if _, ok := profiles[name]; !ok {
+			fmt.Printf("No port profile named %s\n", name)
+			os.Exit(1)
+		}
+		delete(profiles, name)
+
+		assigned := portProfileServers()
+		for id, profile := range assigned {
+			if profile == name {
+				delete(assigned, id)
+			}
+		}
+
+		viper.Set("ports.profiles", profiles)
+		viper.Set("ports.servers", assigned)
+		if err := saveConfig(); err != nil {
+			fmt.Printf("Error deleting port profile : %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Port profile %s deleted\n", name)
+	},
+}
+
+// portDrift is how a server's ports differ from its profile
+type portDrift struct {
+	ServerID string `json:"serverId"`
+	Name     string `json:"name"`
+	Profile  string `json:"profile"`
+	Status   string `json:"status"`
+	Missing  string `json:"missing,omitempty"`
+	Extra    string `json:"extra,omitempty"`
+}
+
+type portDrifts []portDrift
+
+func (d portDrifts) Headers() []string {
+	return []string{"SERVER", "NAME", "PROFILE", "STATUS", "MISSING", "EXTRA"}
+}
+
+func (d portDrifts) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range d {
+		rows = append(rows, []string{p.ServerID, p.Name, p.Profile, p.Status, p.Missing, p.Extra})
+	}
+	return rows
+}
+
+var portsAudit = &cobra.Command{
+	Use:   "audit",
+	Short: "Check servers against their port profiles",
+	Long: `audit [--format table|json]
+
+Compares the open ports of every server given a profile by
+blcli server setports --profile with the profile, and exits with 2 when any
+server has drifted from it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+
+		assigned := portProfileServers()
+		if len(assigned) == 0 {
+			fmt.Println("No servers have a port profile, use blcli server setports --profile <name> <servers...>")
+			return
+		}
+
+		servers, err := client.Server.List()
+		if err != nil {
+			fmt.Printf("Error listing servers : %v\n", err)
+			os.Exit(1)
+		}
+		byID := map[string]gobitlaunch.Server{}
+		for _, s := range *servers {
+			byID[s.ID] = s
+		}
+
+		profiles := portProfiles()
+		drifts := portDrifts{}
+		drifted := false
+		for id, profile := range assigned {
+			d := portDrift{ServerID: id, Profile: profile, Status: "ok"}
+			server, found := byID[id]
+			spec, known := profiles[profile]
+			switch {
+			case !found:
+				d.Status = "server not found"
+			case !known:
+				d.Name = server.Name
+				d.Status = "profile not found"
+			default:
+				d.Name = server.Name
+				want, err := parsePorts(spec)
+				if err != nil {
+					d.Status = err.Error()
+					break
+				}
+				d.Missing = formatPorts(removePorts(want, server.Protection.Ports))
+				d.Extra = formatPorts(removePorts(server.Protection.Ports, want))
+				if !server.Protection.Enabled {
+					d.Status = "protection disabled"
+				} else if len(d.Missing) > 0 || len(d.Extra) > 0 {
+					d.Status = "drifted"
+				}
+			}
+			drifted = drifted || d.Status != "ok"
+			drifts = append(drifts, d)
+		}
+		sort.Slice(drifts, func(i, j int) bool { return drifts[i].ServerID < drifts[j].ServerID })
+
+		printer.OutputAs(format, drifts)
+		if drifted {
+			os.Exit(exitPortsDrift)
+		}
+	},
+}
+
+// portsFromFlags returns the ports given by --ports or --profile, and the
+// name of the profile when one was used
+func portsFromFlags(cmd *cobra.Command) ([]gobitlaunch.Ports, string, error) {
+	ports, _ := cmd.Flags().GetString("ports")
+	profile, _ := cmd.Flags().GetString("profile")
+
+	switch {
+	case len(ports) > 0 && len(profile) > 0:
+		return nil, "", errors.New("--ports and --profile cannot be used together")
+	case len(profile) > 0:
+		spec, ok := portProfiles()[profile]
+		if !ok {
+			return nil, "", fmt.Errorf("no port profile named %s", profile)
+		}
+		list, err := parsePorts(spec)
+		return list, profile, err
+	case len(ports) > 0:
+		list, err := parsePorts(ports)
+		return list, "", err
+	}
+	return nil, "", errors.New("please provide --ports or --profile")
+}
+
+// portProfiles returns the port specs of the profiles in the config file
+func portProfiles() map[string]string {
+	profiles := viper.GetStringMapString("ports.profiles")
+	if profiles == nil {
+		profiles = map[string]string{}
+	}
+	return profiles
+}
+
+func portProfileNames() []string {
+	names := []string{}
+	for name := range portProfiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// portProfileServers returns the profile applied to each server ID
+func portProfileServers() map[string]string {
+	assigned := viper.GetStringMapString("ports.servers")
+	if assigned == nil {
+		assigned = map[string]string{}
+	}
+	return assigned
+}
+
+// assignPortProfile records that a server has a profile's ports, for audit,
+// or with an empty profile that its ports were replaced by others
+func assignPortProfile(id, profile string) error {
+	assigned := portProfileServers()
+	if assigned[id] == profile {
+		return nil
+	}
+	if len(profile) > 0 {
+		assigned[id] = profile
+	} else {
+		delete(assigned, id)
+	}
+	viper.Set("ports.servers", assigned)
+	return saveConfig()
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitlaunchio/gobitlaunch"
+)
+
+// portPresets are port sets that can be used by name in a port list
+var portPresets = map[string]string{
+	"ssh":       "22:tcp",
+	"web":       "80:tcp,443:tcp",
+	"wireguard": "51820:udp",
+}
+
+var portProtocols = []string{"tcp", "udp", "both"}
+
+// maxPortRange is the most ports a single range may open, each port is sent
+// to the API as its own rule
+const maxPortRange = 1024
+
+// parsePorts parses a comma separated list of ports, port ranges and presets
+// such as "ssh,8000-8010:tcp,53:both". The protocol defaults to tcp and both
+// expands to a tcp and a udp rule
+func parsePorts(spec string) ([]gobitlaunch.Ports, error) {
+	ports := []gobitlaunch.Ports{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if len(item) == 0 {
+			continue
+		}
+
+		if preset, ok := portPresets[item]; ok {
+			p, err := parsePorts(preset)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, p...)
+			continue
+		}
+
+		p, err := parsePortRule(item)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p...)
+	}
+
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	return sortPorts(ports), nil
+}
+
+// parsePortRule parses a single port[-port][:protocol] rule
+func parsePortRule(rule string) ([]gobitlaunch.Ports, error) {
+	parts := strings.Split(rule, ":")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("invalid port rule %q, expected port[-port][:protocol]", rule)
+	}
+
+	protocol := "tcp"
+	if len(parts) == 2 {
+		protocol = parts[1]
+	}
+	if !contains(portProtocols, protocol) {
+		return nil, fmt.Errorf("invalid protocol %q in %q, expected one of %s", protocol, rule, strings.Join(portProtocols, ", "))
+	}
+
+	bounds := strings.Split(parts[0], "-")
+	if len(bounds) > 2 {
+		return nil, fmt.Errorf("invalid port range %q in %q", parts[0], rule)
+	}
+	first, err := parsePortNumber(bounds[0], rule)
+	if err != nil {
+		return nil, err
+	}
+	last := first
+	if len(bounds) == 2 {
+		if last, err = parsePortNumber(bounds[1], rule); err != nil {
+			return nil, err
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid port range %q in %q, the first port must not be above the last", parts[0], rule)
+		}
+		if last-first+1 > maxPortRange {
+			return nil, fmt.Errorf("port range %q in %q is too large, at most %d ports are allowed in a range", parts[0], rule, maxPortRange)
+		}
+	}
+
+	protocols := []string{protocol}
+	if protocol == "both" {
+		protocols = []string{"tcp", "udp"}
+	}
+	ports := []gobitlaunch.Ports{}
+	for n := first; n <= last; n++ {
+		for _, p := range protocols {
+			ports = append(ports, gobitlaunch.Ports{PortNumber: n, Protocol: p})
+		}
+	}
+	return ports, nil
+}
+
+func parsePortNumber(s, rule string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q in %q, expected a number or one of %s", s, rule, strings.Join(presetNames(), ", "))
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("invalid port %d in %q, must be between 1 and 65535", n, rule)
+	}
+	return n, nil
+}
+
+func presetNames() []string {
+	names := []string{}
+	for name := range portPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergePorts returns the ports in current and add, without duplicates
+func mergePorts(current, add []gobitlaunch.Ports) []gobitlaunch.Ports {
+	return sortPorts(append(append([]gobitlaunch.Ports{}, current...), add...))
+}
+
+// removePorts returns the ports in current that are not in remove
+func removePorts(current, remove []gobitlaunch.Ports) []gobitlaunch.Ports {
+	removed := map[string]bool{}
+	for _, p := range remove {
+		removed[portKey(p)] = true
+	}
+	ports := []gobitlaunch.Ports{}
+	for _, p := range current {
+		if !removed[portKey(p)] {
+			ports = append(ports, p)
+		}
+	}
+	return sortPorts(ports)
+}
+
+// portKey identifies a rule by its port and protocol
+func portKey(p gobitlaunch.Ports) string {
+	return fmt.Sprintf("%d/%s", p.PortNumber, strings.ToLower(p.Protocol))
+}
+
+// sortPorts orders ports by number then protocol and drops duplicates
+func sortPorts(ports []gobitlaunch.Ports) []gobitlaunch.Ports {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].PortNumber != ports[j].PortNumber {
+			return ports[i].PortNumber < ports[j].PortNumber
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	seen := map[string]bool{}
+	unique := []gobitlaunch.Ports{}
+	for _, p := range ports {
+		if key := portKey(p); !seen[key] {
+			seen[key] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
+
+// formatPorts returns ports as a port:protocol list
+func formatPorts(ports []gobitlaunch.Ports) string {
+	items := []string{}
+	for _, p := range ports {
+		items = append(items, fmt.Sprintf("%d:%s", p.PortNumber, p.Protocol))
+	}
+	return strings.Join(items, ",")
+}
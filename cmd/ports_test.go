@@ -0,0 +1,61 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec  string
+		want  string
+		error bool
+	}{
+		{spec: "22", want: "22:tcp"},
+		{spec: "22:udp", want: "22:udp"},
+		{spec: "8000-8010:tcp", want: "8000:tcp,8001:tcp,8002:tcp,8003:tcp,8004:tcp,8005:tcp,8006:tcp,8007:tcp,8008:tcp,8009:tcp,8010:tcp"},
+		{spec: "53:both", want: "53:tcp,53:udp"},
+		{spec: "ssh", want: "22:tcp"},
+		{spec: "web", want: "80:tcp,443:tcp"},
+		{spec: "wireguard", want: "51820:udp"},
+		{spec: "WEB, 22, 443:TCP", want: "22:tcp,80:tcp,443:tcp"},
+		{spec: "", error: true},
+		{spec: "0", error: true},
+		{spec: "65536", error: true},
+		{spec: "http", error: true},
+		{spec: "22:icmp", error: true},
+		{spec: "22:tcp:udp", error: true},
+		{spec: "8010-8000", error: true},
+		{spec: "1-2-3", error: true},
+		{spec: "1-65535:both", error: true},
+	}
+
+	for _, test := range tests {
+		ports, err := parsePorts(test.spec)
+		if test.error {
+			if err == nil {
+				t.Errorf("parsePorts(%q) = %s, want an error", test.spec, formatPorts(ports))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePorts(%q) returned error: %v", test.spec, err)
+			continue
+		}
+		if got := formatPorts(ports); got != test.want {
+			t.Errorf("parsePorts(%q) = %s, want %s", test.spec, got, test.want)
+		}
+	}
+}
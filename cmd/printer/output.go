@@ -14,20 +14,39 @@ limitations under the License.
 package printer
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
 )
 
 // Format is the type of output to display
 var Format string
 
+// Tabular is implemented by data that can be displayed as a table
+type Tabular interface {
+	Headers() []string
+	Rows() [][]string
+}
+
 // Output writes the output
 func Output(data interface{}) {
+	OutputAs(Format, data)
+}
+
+// OutputAs writes the output in the given format, overriding Format
+func OutputAs(format string, data interface{}) {
 	var err error
-	switch Format {
+	switch format {
 	case "json":
 		err = writeJSON(data)
+	case "table":
+		err = writeTable(data)
+	case "csv":
+		err = writeCSV(data)
 	default:
 		err = errors.New("unknown output format")
 	}
@@ -45,3 +64,29 @@ func writeJSON(data interface{}) error {
 	fmt.Println(string(j))
 	return nil
 }
+
+func writeTable(data interface{}) error {
+	t, ok := data.(Tabular)
+	if !ok {
+		return errors.New("output cannot be displayed as a table")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.Headers(), "\t"))
+	for _, row := range t.Rows() {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func writeCSV(data interface{}) error {
+	t, ok := data.(Tabular)
+	if !ok {
+		return errors.New("output cannot be displayed as csv")
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write(t.Headers())
+	w.WriteAll(t.Rows())
+	return w.Error()
+}
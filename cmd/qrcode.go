@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"rsc.io/qr"
+)
+
+// quietZone is the blank border around a QR code, in modules
+const quietZone = 4
+
+// writeQRFile encodes text as a QR code of roughly size pixels square and
+// writes it to path as a PNG or SVG depending on the extension. A non-empty
+// caption is printed underneath the code.
+func writeQRFile(path, text, caption string, size int) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return err
+	}
+
+	modules := code.Size + 2*quietZone
+	scale := size / modules
+	if scale < 1 {
+		scale = 1
+	}
+
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		data, err = qrPNG(code, scale, caption)
+	case ".svg":
+		data = qrSVG(code, scale, caption)
+	default:
+		return fmt.Errorf("unsupported file type %q, use .png or .svg", filepath.Ext(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func qrPNG(code *qr.Code, scale int, caption string) ([]byte, error) {
+	face := basicfont.Face7x13
+	width := (code.Size + 2*quietZone) * scale
+	height := width
+	if len(caption) > 0 {
+		height += face.Height + quietZone*scale
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			px, py := (x+quietZone)*scale, (y+quietZone)*scale
+			draw.Draw(img, image.Rect(px, py, px+scale, py+scale), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		}
+	}
+
+	if len(caption) > 0 {
+		d := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+		x := (width - d.MeasureString(caption).Ceil()) / 2
+		if x < 0 {
+			x = 0
+		}
+		d.Dot = fixed.P(x, width+face.Ascent)
+		d.DrawString(caption)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func qrSVG(code *qr.Code, scale int, caption string) []byte {
+	width := (code.Size + 2*quietZone) * scale
+	fontSize := 2 * scale
+	if fontSize < 12 {
+		fontSize = 12
+	}
+	height := width
+	if len(caption) > 0 {
+		height += 2 * fontSize
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", width, height, width, height)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", width, height)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d"/>`+"\n", (x+quietZone)*scale, (y+quietZone)*scale, scale, scale)
+			}
+		}
+	}
+	if len(caption) > 0 {
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" font-family="monospace" font-size="%d" text-anchor="middle">%s</text>`+"\n", width/2, width+fontSize, fontSize, html.EscapeString(caption))
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"./printer"
 	"github.com/bitlaunchio/gobitlaunch"
@@ -31,6 +32,7 @@ var (
 	cfgFile string
 	token   string
 	format  string
+	dryRun  bool
 
 	rootCmd = &cobra.Command{
 		Use:   "blcli",
@@ -39,6 +41,9 @@ var (
 	}
 )
 
+// hostNames are the providers servers can be created on
+var hostNames = []string{"bitlaunch", "digitalocean", "vultr", "linode"}
+
 func hostID(name string) (int, error) {
 	var err error
 	var h int
@@ -57,6 +62,16 @@ func hostID(name string) (int, error) {
 	return h, err
 }
 
+// hostName is the reverse of hostID
+func hostName(id int) string {
+	for _, name := range hostNames {
+		if h, _ := hostID(name); h == id {
+			return name
+		}
+	}
+	return fmt.Sprintf("host-%d", id)
+}
+
 // Execute executes the root command.
 func Execute() error {
 	return rootCmd.Execute()
@@ -69,15 +84,31 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.blcli.yaml)")
 	rootCmd.PersistentFlags().StringVar(&token, "token", "", "API authentication token")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "validate and print the request that would be sent without calling the API")
+	rootCmd.PersistentFlags().BoolVar(&refresh, "refresh", false, "ignore cached data and fetch it from the API")
 	//rootCmd.PersistentFlags().StringVar(&format, "format", "json", "output format. can be: kv, csv or json (default)")
 	rootCmd.MarkFlagRequired("token")
 
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(completionCmd)
 	rootCmd.AddCommand(Account())
 	rootCmd.AddCommand(Server())
 	rootCmd.AddCommand(Transaction())
 	rootCmd.AddCommand(CreateOptions())
 	rootCmd.AddCommand(SSHKey())
+	rootCmd.AddCommand(Cache())
+	rootCmd.AddCommand(Budget())
+	rootCmd.AddCommand(Ports())
+}
+
+// contains reports whether list includes s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 func er(msg interface{}) {
@@ -103,17 +134,37 @@ func initConfig() {
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	if err := viper.ReadInConfig(); err == nil && !completing() {
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// saveConfig writes the current settings to the config file, creating
+// $HOME/.blcli.yaml when there is none yet
+func saveConfig() error {
+	if len(viper.ConfigFileUsed()) > 0 {
+		return viper.WriteConfig()
 	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return err
+	}
+	return viper.WriteConfigAs(filepath.Join(home, ".blcli.yaml"))
 }
 
 func initClient() {
-	if versionCmd.CalledAs() == "version" {
-		return
+	// these commands only touch local files and never call the API
+	for _, c := range []*cobra.Command{versionCmd, cacheClear, completionCmd, budgetSet, portsProfileCreate, portsProfileList, portsProfileDelete} {
+		if c.CalledAs() != "" {
+			return
+		}
 	}
 	if len(token) == 0 {
 		token = os.Getenv("BL_API_TOKEN")
+		if len(token) == 0 && completing() {
+			return
+		}
 		if len(token) == 0 {
 			fmt.Println("You must specify your API token with either the --token parameter or by exporting it as an environment variable:")
 			fmt.Println("export BL_API_TOKEN='<your_token_here>'")
@@ -0,0 +1,284 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitlaunchio/gobitlaunch"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// ANSI sequences used by the live table
+const (
+	clearScreen = "\033[H\033[2J"
+	colorReset  = "\033[0m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33;1m"
+	colorRed    = "\033[31m"
+)
+
+// watchRow is the state of a server shown by server watch
+type watchRow struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	IP         string `json:"ip"`
+	Host       string `json:"host"`
+	Region     string `json:"region"`
+	Size       string `json:"size"`
+	Cost       string `json:"cost"`
+	Protection string `json:"protection"`
+}
+
+var watchHeaders = []string{"ID", "NAME", "STATUS", "IP", "HOST", "REGION", "SIZE", "COST", "PROTECTION"}
+
+func (r watchRow) cells() []string {
+	return []string{r.ID, r.Name, r.Status, r.IP, r.Host, r.Region, r.Size, r.Cost, r.Protection}
+}
+
+func newWatchRow(s gobitlaunch.Server) watchRow {
+	row := watchRow{
+		ID:         s.ID,
+		Name:       s.Name,
+		Status:     s.Status,
+		IP:         s.Ipv4,
+		Host:       hostName(s.HostID),
+		Region:     s.Region,
+		Size:       s.Size,
+		Cost:       "-",
+		Protection: "off",
+	}
+	if len(s.SizeDesc) > 0 {
+		row.Size = s.SizeDesc
+	}
+	if cost, ok := serverHourlyCost(s); ok {
+		row.Cost = fmt.Sprintf("$%.3f/hr", cost)
+	}
+	if s.Protection.Enabled {
+		row.Protection = "on"
+		if ports := formatPorts(sortPorts(append([]gobitlaunch.Ports{}, s.Protection.Ports...))); len(ports) > 0 {
+			row.Protection += " " + ports
+		}
+	}
+	return row
+}
+
+// watchChange is the old and new value of a changed field
+type watchChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// watchEvent is a server being added, removed or changed
+type watchEvent struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Server  *watchRow              `json:"server,omitempty"`
+	Changes map[string]watchChange `json:"changes,omitempty"`
+}
+
+var serverWatch = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch your servers in a live table",
+	Long: `watch [--interval 10s] [--events] [--once]
+
+Lists the servers again every interval and redraws a table of them, with new
+servers in green, changed values in yellow and removed servers in red until
+the next refresh. With --events a JSON line is written for every server
+added, removed or changed instead, starting with an added event for each
+existing server. --once stops after the first listing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		events, _ := cmd.Flags().GetBool("events")
+		once, _ := cmd.Flags().GetBool("once")
+
+		if interval < time.Second {
+			fmt.Println("Please specify an --interval of at least 1s")
+			os.Exit(1)
+		}
+
+		color := term.IsTerminal(int(os.Stdout.Fd()))
+		encoder := json.NewEncoder(os.Stdout)
+		previous := map[string]watchRow{}
+		for {
+			servers, err := client.Server.List()
+			now := time.Now()
+			if err != nil {
+				if once {
+					fmt.Printf("Error listing servers : %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stderr, "Error listing servers : %v\n", err)
+				time.Sleep(interval)
+				continue
+			}
+
+			current := map[string]watchRow{}
+			for _, s := range *servers {
+				current[s.ID] = newWatchRow(s)
+			}
+
+			if events {
+				for _, e := range watchEvents(previous, current, now) {
+					if err := encoder.Encode(e); err != nil {
+						fmt.Fprintf(os.Stderr, "Error writing event : %v\n", err)
+						os.Exit(1)
+					}
+				}
+			} else {
+				if color {
+					fmt.Print(clearScreen)
+				}
+				fmt.Printf("Every %s, updated %s\n\n", interval, now.Format("15:04:05"))
+				renderWatchTable(previous, current, color && len(previous) > 0)
+			}
+
+			if once {
+				return
+			}
+			previous = current
+			time.Sleep(interval)
+		}
+	},
+}
+
+// watchEvents compares two listings of servers
+func watchEvents(previous, current map[string]watchRow, now time.Time) []watchEvent {
+	events := []watchEvent{}
+	for _, id := range watchIDs(previous, current) {
+		old, had := previous[id]
+		row, has := current[id]
+		switch {
+		case !had:
+			events = append(events, watchEvent{Time: now, Type: "added", ID: id, Name: row.Name, Server: &row})
+		case !has:
+			events = append(events, watchEvent{Time: now, Type: "removed", ID: id, Name: old.Name})
+		default:
+			changes := map[string]watchChange{}
+			oldCells, newCells := old.cells(), row.cells()
+			for i, header := range watchHeaders {
+				if oldCells[i] != newCells[i] {
+					changes[strings.ToLower(header)] = watchChange{From: oldCells[i], To: newCells[i]}
+				}
+			}
+			if len(changes) > 0 {
+				events = append(events, watchEvent{Time: now, Type: "changed", ID: id, Name: row.Name, Server: &row, Changes: changes})
+			}
+		}
+	}
+	return events
+}
+
+// renderWatchTable prints the servers, coloring what changed since the
+// previous listing when highlight is set
+func renderWatchTable(previous, current map[string]watchRow, highlight bool) {
+	ids := watchIDs(previous, current)
+	if !highlight {
+		ids = watchIDs(nil, current)
+	}
+
+	widths := make([]int, len(watchHeaders))
+	measure := func(cells []string) {
+		for i, c := range cells {
+			if len(c) > widths[i] {
+				widths[i] = len(c)
+			}
+		}
+	}
+	measure(watchHeaders)
+	for _, id := range ids {
+		if row, ok := current[id]; ok {
+			measure(row.cells())
+		} else {
+			measure(previous[id].cells())
+		}
+	}
+
+	printRow := func(cells []string, colors []string) {
+		line := ""
+		for i, c := range cells {
+			cell := fmt.Sprintf("%-*s", widths[i], c)
+			if i == len(cells)-1 {
+				cell = c
+			}
+			if len(colors[i]) > 0 {
+				cell = colors[i] + cell + colorReset
+			}
+			line += cell
+			if i < len(cells)-1 {
+				line += "   "
+			}
+		}
+		fmt.Println(line)
+	}
+
+	none := make([]string, len(watchHeaders))
+	printRow(watchHeaders, none)
+	for _, id := range ids {
+		old, had := previous[id]
+		row, has := current[id]
+		colors := make([]string, len(watchHeaders))
+		switch {
+		case !highlight:
+		case !had:
+			for i := range colors {
+				colors[i] = colorGreen
+			}
+		case !has:
+			for i := range colors {
+				colors[i] = colorRed
+			}
+			row = old
+		default:
+			oldCells, newCells := old.cells(), row.cells()
+			for i := range colors {
+				if oldCells[i] != newCells[i] {
+					colors[i] = colorYellow
+				}
+			}
+		}
+		printRow(row.cells(), colors)
+	}
+}
+
+// watchIDs returns the IDs in either listing, ordered by server name
+func watchIDs(previous, current map[string]watchRow) []string {
+	names := map[string]string{}
+	for id, row := range previous {
+		names[id] = row.Name
+	}
+	for id, row := range current {
+		names[id] = row.Name
+	}
+	ids := []string{}
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if names[ids[i]] != names[ids[j]] {
+			return names[ids[i]] < names[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
@@ -18,7 +18,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"./printer"
 	"github.com/bitlaunchio/gobitlaunch"
@@ -44,21 +44,38 @@ func Server() *cobra.Command {
 	cmd.AddCommand(serverRestart)
 	cmd.AddCommand(serverProtection)
 	cmd.AddCommand(serverSetPorts)
+	cmd.AddCommand(serverWatch)
+
+	serverProtection.AddCommand(serverProtectionEnable)
+	serverProtection.AddCommand(serverProtectionDisable)
+	serverProtection.AddCommand(serverProtectionStatus)
 
 	serverCreate.Flags().StringP("name", "n", "", "name for the new server")
 	serverCreate.Flags().StringP("host", "t", "", "target provider/host name: bitlaunch, digitalocean, vultr or linode")
-	serverCreate.Flags().StringP("image", "i", "", "image/app id")
-	serverCreate.Flags().StringP("size", "s", "", "plan/size id")
-	serverCreate.Flags().StringP("region", "r", "", "region id")
+	serverCreate.Flags().StringP("image", "i", "", "image/app id or name, e.g. \"ubuntu 22.04\"")
+	serverCreate.Flags().StringP("size", "s", "", "plan/size id or name, e.g. 2gb")
+	serverCreate.Flags().StringP("region", "r", "", "region id or name, e.g. london")
 	serverCreate.Flags().StringSliceP("sshkey", "k", []string{}, "ssh key ids, comma separated for more than one")
 	serverCreate.Flags().StringP("password", "p", "", "password")
 
-	serverRebuild.Flags().StringP("image", "i", "", "image/app id")
-	serverRebuild.Flags().StringP("description", "d", "", "image/app description")
+	serverRebuild.Flags().StringP("image", "i", "", "image/app id or name")
+	serverRebuild.Flags().StringP("description", "d", "", "image/app description (default is the description of the image)")
 
 	serverResize.Flags().StringP("size", "s", "", "plan/size id")
 
-	serverSetPorts.Flags().StringP("ports", "p", "", "port:protocol, comma separated for more than one")
+	serverSetPorts.Flags().StringP("ports", "p", "", "port[-port][:tcp|udp|both] or a preset (ssh, web, wireguard), comma separated for more than one")
+	serverSetPorts.Flags().String("profile", "", "name of a port profile to use instead of --ports")
+	serverSetPorts.Flags().Bool("add", false, "add the ports to the server's current ports")
+	serverSetPorts.Flags().Bool("remove", false, "remove the ports from the server's current ports")
+
+	serverProtectionEnable.Flags().StringP("ports", "p", "", "ports to open, see setports")
+	serverProtectionEnable.Flags().String("profile", "", "name of a port profile to open instead of --ports")
+
+	serverProtectionStatus.Flags().StringP("format", "f", "table", "output format: table or json")
+
+	serverWatch.Flags().Duration("interval", 10*time.Second, "time between refreshes")
+	serverWatch.Flags().Bool("events", false, "write a JSON line for every server added, removed or changed instead of a table")
+	serverWatch.Flags().Bool("once", false, "list the servers once and exit")
 
 	serverCreate.MarkFlagRequired("name")
 	serverCreate.MarkFlagRequired("host")
@@ -67,20 +84,28 @@ func Server() *cobra.Command {
 	serverCreate.MarkFlagRequired("region")
 
 	serverRebuild.MarkFlagRequired("image")
-	serverRebuild.MarkFlagRequired("description")
 
 	serverResize.MarkFlagRequired("size")
 
-	serverSetPorts.MarkFlagRequired("ports")
+	serverCreate.RegisterFlagCompletionFunc("host", completeHosts)
+	serverCreate.RegisterFlagCompletionFunc("image", completeCreateOption(imageChoices))
+	serverCreate.RegisterFlagCompletionFunc("size", completeCreateOption(sizeChoices))
+	serverCreate.RegisterFlagCompletionFunc("region", completeCreateOption(regionChoices))
+	serverCreate.RegisterFlagCompletionFunc("sshkey", completeSSHKeys)
+	serverSetPorts.RegisterFlagCompletionFunc("profile", completePortProfiles)
+	serverProtectionEnable.RegisterFlagCompletionFunc("profile", completePortProfiles)
+	serverRebuild.RegisterFlagCompletionFunc("image", completeServerCreateOption(imageChoices))
+	serverResize.RegisterFlagCompletionFunc("size", completeServerCreateOption(sizeChoices))
 
 	return cmd
 }
 
 var serverGet = &cobra.Command{
-	Use:     "get",
-	Short:   "Get information for a single server",
-	Long:    `get <server-id>`,
-	Aliases: []string{"g", "show"},
+	Use:               "get",
+	Short:             "Get information for a single server",
+	Long:              `get <server-id>`,
+	Aliases:           []string{"g", "show"},
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -116,10 +141,11 @@ var serverList = &cobra.Command{
 }
 
 var serverDestroy = &cobra.Command{
-	Use:     "destroy",
-	Short:   "Permanently delete a server",
-	Long:    `destroy <server-id>`,
-	Aliases: []string{"delete", "d", "del", "rm"},
+	Use:               "destroy",
+	Short:             "Permanently delete a server",
+	Long:              `destroy <server-id>`,
+	Aliases:           []string{"delete", "d", "del", "rm"},
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -128,6 +154,10 @@ var serverDestroy = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		id := args[0]
+		if printDryRun("server destroy", id, nil) {
+			return
+		}
+
 		err := client.Server.Destroy(id)
 		if err != nil {
 			fmt.Printf("Error destroying server : %v\n", err)
@@ -167,6 +197,14 @@ var serverCreate = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if err := resolveServerOptions(&opts); err != nil {
+			fmt.Printf("Error validating server : %v\n", err)
+			os.Exit(1)
+		}
+		if printDryRun("server create", "", opts) {
+			return
+		}
+
 		server, err := client.Server.Create(&opts)
 		if err != nil {
 			fmt.Printf("Error creating server : %v\n", err)
@@ -178,10 +216,11 @@ var serverCreate = &cobra.Command{
 }
 
 var serverRebuild = &cobra.Command{
-	Use:     "rebuild",
-	Short:   "Rebuild a server",
-	Long:    `rebuild <server-id>`,
-	Aliases: []string{},
+	Use:               "rebuild",
+	Short:             "Rebuild a server",
+	Long:              `rebuild <server-id>`,
+	Aliases:           []string{},
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -194,7 +233,26 @@ var serverRebuild = &cobra.Command{
 		opts.ID, _ = cmd.Flags().GetString("image")
 		opts.Description, _ = cmd.Flags().GetString("description")
 
-		err := client.Server.Rebuild(id, &opts)
+		server, err := client.Server.Show(id)
+		if err != nil {
+			fmt.Printf("Error getting server : %v\n", err)
+			os.Exit(1)
+		}
+		image, err := resolveImage(server.HostID, opts.ID)
+		if err != nil {
+			fmt.Printf("Error validating image : %v\n", err)
+			os.Exit(1)
+		}
+		opts.ID = image.ID
+		if len(opts.Description) == 0 {
+			opts.Description = imageDescription(server.HostID, image.ID)
+		}
+
+		if printDryRun("server rebuild", id, opts) {
+			return
+		}
+
+		err = client.Server.Rebuild(id, &opts)
 		if err != nil {
 			fmt.Printf("Error rebuilding server : %v\n", err)
 			os.Exit(1)
@@ -205,10 +263,11 @@ var serverRebuild = &cobra.Command{
 }
 
 var serverResize = &cobra.Command{
-	Use:     "resize",
-	Short:   "Resize a server",
-	Long:    `resize <server-id>`,
-	Aliases: []string{},
+	Use:               "resize",
+	Short:             "Resize a server",
+	Long:              `resize <server-id>`,
+	Aliases:           []string{},
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -219,6 +278,21 @@ var serverResize = &cobra.Command{
 		id := args[0]
 		sizeID, _ := cmd.Flags().GetString("size")
 
+		if dryRun {
+			server, err := client.Server.Show(id)
+			if err != nil {
+				fmt.Printf("Error getting server : %v\n", err)
+				os.Exit(1)
+			}
+			if err := validateSize(server.HostID, sizeID); err != nil {
+				fmt.Printf("Error validating size : %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if printDryRun("server resize", id, map[string]string{"size": sizeID}) {
+			return
+		}
+
 		err := client.Server.Resize(id, sizeID)
 		if err != nil {
 			fmt.Printf("Error resizing server : %v\n", err)
@@ -230,10 +304,11 @@ var serverResize = &cobra.Command{
 }
 
 var serverRestart = &cobra.Command{
-	Use:     "restart",
-	Short:   "Restart a server",
-	Long:    `restart <server-id>`,
-	Aliases: []string{"reboot"},
+	Use:               "restart",
+	Short:             "Restart a server",
+	Long:              `restart <server-id>`,
+	Aliases:           []string{"reboot"},
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -242,6 +317,10 @@ var serverRestart = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		id := args[0]
+		if printDryRun("server restart", id, nil) {
+			return
+		}
+
 		err := client.Server.Restart(id)
 		if err != nil {
 			fmt.Printf("Error restarting server : %v\n", err)
@@ -254,46 +333,83 @@ var serverRestart = &cobra.Command{
 
 var serverProtection = &cobra.Command{
 	Use:     "protection",
-	Short:   "Protect a server",
-	Long:    `protection <server-id> [enable true e] or [disable false d]`,
+	Short:   "Show, enable or disable server protection",
+	Long:    `Use the subcommands to show, enable or disable the protection of a server.`,
 	Aliases: []string{"protect"},
+	Run: func(cmd *cobra.Command, args []string) {
+		// the old form took the server and state as arguments, which must
+		// not pass silently now that they are subcommands
+		if len(args) > 0 {
+			fmt.Printf("Unknown protection command %q, use: blcli server protection enable|disable|status <server-id>\n", args[0])
+			os.Exit(1)
+		}
+		cmd.Help()
+	},
+}
+
+var serverProtectionEnable = &cobra.Command{
+	Use:   "enable",
+	Short: "Enable protection for a server",
+	Long: `enable <server-id> [--ports 22,80:tcp | --profile <name>]
+
+With --ports or --profile the open ports are set in the same step, see
+blcli server setports --help for the port syntax.`,
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
 		}
-		if len(args) < 2 {
-			return errors.New("please provide a protection state")
-		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		id := args[0]
 
-		server, err := client.Server.Protection(id, func() bool {
-			if args[1] == "enable" || args[1] == "true" || args[1] == "e" {
-				return true
-			} else if args[1] == "disable" || args[1] == "false" || args[1] == "d" {
-				return false
+		var ports []gobitlaunch.Ports
+		profile := ""
+		if cmd.Flags().Changed("ports") || cmd.Flags().Changed("profile") {
+			var err error
+			ports, profile, err = portsFromFlags(cmd)
+			if err != nil {
+				fmt.Printf("Error setting server ports : %v\n", err)
+				os.Exit(1)
 			}
+		}
 
-			fmt.Println("Invalid protection state")
-			os.Exit(1)
-			return false
-		}())
+		if dryRun {
+			printDryRun("server protection", id, map[string]bool{"enabled": true})
+			if ports != nil {
+				printDryRun("server setports", id, ports)
+			}
+			return
+		}
+
+		server, err := client.Server.Protection(id, true)
 		if err != nil {
-			fmt.Printf("Error resizing server : %v\n", err)
+			fmt.Printf("Error enabling server protection : %v\n", err)
 			os.Exit(1)
 		}
 
+		if ports != nil {
+			server, err = client.Server.SetPorts(id, &ports)
+			if err != nil {
+				fmt.Printf("Error setting server ports : %v\n", err)
+				os.Exit(1)
+			}
+			if err := assignPortProfile(id, profile); err != nil {
+				fmt.Printf("Error saving port profile : %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		printer.Output(server)
 	},
 }
 
-var serverSetPorts = &cobra.Command{
-	Use:     "setports",
-	Short:   "Set ports for a protected server",
-	Long:    `setports <server-id>`,
-	Aliases: []string{"ports"},
+var serverProtectionDisable = &cobra.Command{
+	Use:               "disable",
+	Short:             "Disable protection for a server",
+	Long:              `disable <server-id>`,
+	ValidArgsFunction: completeServers,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a server ID")
@@ -302,32 +418,161 @@ var serverSetPorts = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		id := args[0]
-		ports, _ := cmd.Flags().GetString("ports")
 
-		portItems := strings.Split(ports, ",")
-		portList := []gobitlaunch.Ports{}
+		if printDryRun("server protection", id, map[string]bool{"enabled": false}) {
+			return
+		}
+
+		server, err := client.Server.Protection(id, false)
+		if err != nil {
+			fmt.Printf("Error disabling server protection : %v\n", err)
+			os.Exit(1)
+		}
 
-		for _, port := range portItems {
-			portObj := strings.Split(port, ":")
+		printer.Output(server)
+	},
+}
 
-			num, err := strconv.Atoi(portObj[0])
-			if err != nil {
-				fmt.Printf("Error setting server ports : %v\n", err)
-				os.Exit(1)
-			}
+// protectionStatus is the protection state and open ports of a server
+type protectionStatus struct {
+	ServerID string              `json:"serverId"`
+	Name     string              `json:"name"`
+	Enabled  bool                `json:"enabled"`
+	Ports    []gobitlaunch.Ports `json:"ports"`
+}
+
+func (p protectionStatus) Headers() []string {
+	return []string{"PORT", "PROTOCOL"}
+}
+
+func (p protectionStatus) Rows() [][]string {
+	rows := [][]string{}
+	for _, port := range p.Ports {
+		rows = append(rows, []string{strconv.Itoa(port.PortNumber), port.Protocol})
+	}
+	return rows
+}
+
+var serverProtectionStatus = &cobra.Command{
+	Use:               "status",
+	Short:             "Show the protection state and open ports of a server",
+	Long:              `status <server-id> [--format table|json]`,
+	Aliases:           []string{"show"},
+	ValidArgsFunction: completeServers,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a server ID")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+
+		server, err := client.Server.Show(args[0])
+		if err != nil {
+			fmt.Printf("Error getting server : %v\n", err)
+			os.Exit(1)
+		}
+
+		status := protectionStatus{
+			ServerID: server.ID,
+			Name:     server.Name,
+			Enabled:  server.Protection.Enabled,
+			Ports:    sortPorts(append([]gobitlaunch.Ports{}, server.Protection.Ports...)),
+		}
+		if format != "table" {
+			printer.OutputAs(format, status)
+			return
+		}
+
+		state := "disabled"
+		if status.Enabled {
+			state = "enabled"
+		}
+		fmt.Printf("Protection of %s (%s) is %s\n", status.Name, status.ServerID, state)
+		if len(status.Ports) == 0 {
+			fmt.Println("No open ports")
+			return
+		}
+		fmt.Println()
+		printer.OutputAs(format, status)
+	},
+}
+
+var serverSetPorts = &cobra.Command{
+	Use:   "setports",
+	Short: "Set ports for protected servers",
+	Long: `setports <server-id>... --ports 22,80:tcp,8000-8010:tcp,53:both | --profile <name> [--add|--remove]
+
+The protocol defaults to tcp, and both opens the port for tcp and udp. The
+presets ssh, web and wireguard can be used in place of port numbers, and
+--profile uses the ports of a profile made with blcli ports profile create.
+By default the ports replace the server's current ports, --add and --remove
+change the current ports instead. Servers given a profile's ports are
+checked against it by blcli ports audit, until their ports are replaced
+without --profile.`,
+	Aliases:           []string{"ports"},
+	ValidArgsFunction: completeServerArgs,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a server ID")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		add, _ := cmd.Flags().GetBool("add")
+		remove, _ := cmd.Flags().GetBool("remove")
 
-			portList = append(portList, gobitlaunch.Ports{
-				PortNumber: num,
-				Protocol:   portObj[1],
-			})
+		if add && remove {
+			fmt.Println("Error setting server ports : --add and --remove cannot be used together")
+			os.Exit(1)
 		}
 
-		server, err := client.Server.SetPorts(id, &portList)
+		ports, profile, err := portsFromFlags(cmd)
 		if err != nil {
 			fmt.Printf("Error setting server ports : %v\n", err)
 			os.Exit(1)
 		}
 
-		printer.Output(server)
+		failed := false
+		for _, id := range args {
+			portList := ports
+			if add || remove {
+				server, err := client.Server.Show(id)
+				if err != nil {
+					fmt.Printf("Error getting server %s : %v\n", id, err)
+					failed = true
+					continue
+				}
+				if add {
+					portList = mergePorts(server.Protection.Ports, ports)
+				} else {
+					portList = removePorts(server.Protection.Ports, ports)
+				}
+			}
+
+			if printDryRun("server setports", id, portList) {
+				continue
+			}
+
+			server, err := client.Server.SetPorts(id, &portList)
+			if err != nil {
+				fmt.Printf("Error setting server ports for %s : %v\n", id, err)
+				failed = true
+				continue
+			}
+			if !add && !remove {
+				if err := assignPortProfile(id, profile); err != nil {
+					fmt.Printf("Error saving port profile of %s : %v\n", id, err)
+					failed = true
+				}
+			}
+
+			printer.Output(server)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
@@ -0,0 +1,273 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// publicKey is an ssh public key in authorized_keys format
+type publicKey struct {
+	Key     ssh.PublicKey
+	Comment string
+	Content string
+}
+
+// parsePublicKey validates a single public key in authorized_keys format.
+// Only ssh-ed25519, ssh-rsa and ecdsa-sha2-* keys are accepted.
+func parsePublicKey(content string) (*publicKey, error) {
+	content = strings.TrimSpace(content)
+	if len(content) == 0 {
+		return nil, errors.New("empty ssh key")
+	}
+	if strings.Contains(content, "PRIVATE KEY") {
+		return nil, errors.New("this is a private key, use the .pub file")
+	}
+
+	key, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh public key : %v", err)
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, errors.New("more than one ssh key given")
+	}
+
+	t := key.Type()
+	if t != ssh.KeyAlgoED25519 && t != ssh.KeyAlgoRSA && !strings.HasPrefix(t, "ecdsa-sha2-") {
+		return nil, fmt.Errorf("unsupported key type %s, use ssh-ed25519, ssh-rsa or ecdsa-sha2-*", t)
+	}
+
+	return &publicKey{
+		Key:     key,
+		Comment: comment,
+		Content: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + commentSuffix(comment),
+	}, nil
+}
+
+func commentSuffix(comment string) string {
+	if len(comment) == 0 {
+		return ""
+	}
+	return " " + comment
+}
+
+// readPublicKeyFile reads and validates a public key from a file, or from
+// stdin when path is "-"
+func readPublicKeyFile(path string) (*publicKey, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		path, err = homedir.Expand(path)
+		if err == nil {
+			data, err = ioutil.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKey(string(data))
+}
+
+// sshDir returns the user's ~/.ssh directory
+func sshDir() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh"), nil
+}
+
+// localPublicKeyFiles lists the *.pub files in ~/.ssh
+func localPublicKeyFiles() ([]string, error) {
+	dir, err := sshDir()
+	if err != nil {
+		return nil, err
+	}
+	return filepath.Glob(filepath.Join(dir, "*.pub"))
+}
+
+// keyBits returns the size in bits of a public key
+func keyBits(key ssh.PublicKey) int {
+	ck, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := ck.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// localKeys maps the SHA256 fingerprints of the private keys available
+// locally, in ~/.ssh or loaded in the ssh-agent, to where they were found
+func localKeys() map[string]string {
+	found := map[string]string{}
+
+	if dir, err := sshDir(); err == nil {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, file := range files {
+			if strings.HasSuffix(file, ".pub") {
+				continue
+			}
+			if key := privateKeyPublicHalf(file); key != nil {
+				found[ssh.FingerprintSHA256(key)] = file
+			}
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			defer conn.Close()
+			keys, _ := agent.NewClient(conn).List()
+			for _, key := range keys {
+				if _, ok := found[ssh.FingerprintSHA256(key)]; !ok {
+					found[ssh.FingerprintSHA256(key)] = "ssh-agent"
+				}
+			}
+		}
+	}
+
+	return found
+}
+
+// privateKeyPublicHalf returns the public key of a private key file, without
+// asking for its passphrase. Encrypted keys are matched through their .pub
+// file when their format does not store the public key in the clear.
+func privateKeyPublicHalf(path string) ssh.PublicKey {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "PRIVATE KEY") {
+		return nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		return signer.PublicKey()
+	}
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok && missing.PublicKey != nil {
+		return missing.PublicKey
+	}
+	if key, err := readPublicKeyFile(path + ".pub"); err == nil {
+		return key.Key
+	}
+	return nil
+}
+
+// generateKey creates a new private key of the given type, ed25519, rsa or
+// ecdsa, with bits used by rsa and ecdsa keys
+func generateKey(keyType string, bits int) (crypto.PrivateKey, error) {
+	switch keyType {
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case "rsa":
+		if bits == 0 {
+			bits = 4096
+		}
+		if bits < 2048 {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("ecdsa keys must be 256, 384 or 521 bits")
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q, use ed25519, rsa or ecdsa", keyType)
+	}
+}
+
+// writeKeyPair writes a private key to path in OpenSSH format, encrypted when
+// passphrase is not empty, and its public half to path.pub. Existing files
+// are never overwritten.
+func writeKeyPair(path string, key crypto.PrivateKey, pub ssh.PublicKey, comment string, passphrase []byte) error {
+	var block *pem.Block
+	var err error
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	content := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + commentSuffix(comment) + "\n"
+
+	// both files are created exclusively so an existing key is never replaced
+	if err := writeNewFile(path+".pub", []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := writeNewFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		os.Remove(path + ".pub")
+		return err
+	}
+	return nil
+}
+
+// writeNewFile writes data to a file that must not exist yet
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
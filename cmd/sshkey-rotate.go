@@ -0,0 +1,434 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"./printer"
+	"github.com/bitlaunchio/gobitlaunch"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// rotateState is the progress of an sshkey rotate, saved after every step so
+// that running the same rotation again resumes it
+type rotateState struct {
+	OldKeyID      string                   `json:"oldKeyId"`
+	NewKeyID      string                   `json:"newKeyId"`
+	OldKeyDeleted bool                     `json:"oldKeyDeleted"`
+	Servers       map[string]*rotateServer `json:"servers"`
+}
+
+// rotateServer is the progress of a rotation on a single server
+type rotateServer struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip"`
+	Added    bool   `json:"added"`
+	Verified bool   `json:"verified"`
+	Removed  bool   `json:"removed"`
+	Error    string `json:"error,omitempty"`
+}
+
+type rotateReport []*rotateServer
+
+func (r rotateReport) Headers() []string {
+	return []string{"SERVER", "IP", "ADDED", "VERIFIED", "REMOVED", "ERROR"}
+}
+
+func (r rotateReport) Rows() [][]string {
+	rows := [][]string{}
+	for _, s := range r {
+		rows = append(rows, []string{s.Name, s.IP, strconv.FormatBool(s.Added), strconv.FormatBool(s.Verified), strconv.FormatBool(s.Removed), s.Error})
+	}
+	return rows
+}
+
+var sshKeyRotate = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace an ssh key on the account and on the servers using it",
+	Long: `rotate --old <key-id> --new <file.pub> [--identity ~/.ssh/old_key] [--user root]
+
+Registers the new key, then on every server created with the old key logs in
+over ssh with the old key, adds the new key to ~/.ssh/authorized_keys, checks
+that the new key can log in and removes the old key. Once every server is
+done the old key is deleted from the account. Progress is saved, so running
+the same command again resumes a rotation that failed part way.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		oldID, _ := cmd.Flags().GetString("old")
+		newFile, _ := cmd.Flags().GetString("new")
+		identity, _ := cmd.Flags().GetString("identity")
+		newIdentity, _ := cmd.Flags().GetString("new-identity")
+		user, _ := cmd.Flags().GetString("user")
+		serverIDs, _ := cmd.Flags().GetStringSlice("servers")
+		insecure, _ := cmd.Flags().GetBool("insecure-ignore-host-key")
+
+		keys, err := client.SSHKey.List()
+		if err != nil {
+			fmt.Printf("Error listing ssh keys : %v\n", err)
+			os.Exit(1)
+		}
+		var oldKey *publicKey
+		for _, k := range *keys {
+			if k.ID == oldID {
+				oldKey, err = parsePublicKey(k.Content)
+				if err != nil {
+					fmt.Printf("Error reading ssh key %s : %v\n", k.Name, err)
+					os.Exit(1)
+				}
+			}
+		}
+		if oldKey == nil {
+			fmt.Printf("No ssh key with ID %s on your account\n", oldID)
+			os.Exit(1)
+		}
+
+		newKey, err := readPublicKeyFile(newFile)
+		if err != nil {
+			fmt.Printf("Error reading ssh key : %v\n", err)
+			os.Exit(1)
+		}
+		if ssh.FingerprintSHA256(newKey.Key) == ssh.FingerprintSHA256(oldKey.Key) {
+			fmt.Println("The new key is the same as the old key")
+			os.Exit(1)
+		}
+		if len(newIdentity) == 0 {
+			newIdentity = strings.TrimSuffix(newFile, ".pub")
+		}
+
+		stateFile := "rotate-" + oldID + ".json"
+		state := rotateState{OldKeyID: oldID, Servers: map[string]*rotateServer{}}
+		if err := readState(stateFile, &state); err != nil {
+			fmt.Printf("Error reading rotation state : %v\n", err)
+			os.Exit(1)
+		}
+		if len(state.NewKeyID) > 0 {
+			registered := false
+			for _, k := range *keys {
+				if k.ID != state.NewKeyID {
+					continue
+				}
+				registered = true
+				if saved, err := parsePublicKey(k.Content); err != nil || ssh.FingerprintSHA256(saved.Key) != ssh.FingerprintSHA256(newKey.Key) {
+					fmt.Printf("This rotation was started with ssh key %s, which is not %s\n", state.NewKeyID, newFile)
+					os.Exit(1)
+				}
+			}
+			if !registered {
+				state.NewKeyID = ""
+			}
+		}
+
+		servers, err := client.Server.List()
+		if err != nil {
+			fmt.Printf("Error listing servers : %v\n", err)
+			os.Exit(1)
+		}
+		// only servers that still exist and are selected are rotated, keeping
+		// the progress saved for them by an earlier run
+		selected := map[string]*rotateServer{}
+		for _, s := range *servers {
+			if len(serverIDs) > 0 && !contains(serverIDs, s.ID) {
+				continue
+			}
+			if len(serverIDs) == 0 && !contains(s.SSHKeys, oldID) {
+				continue
+			}
+			server := &rotateServer{Name: s.Name, IP: s.Ipv4}
+			if saved, ok := state.Servers[s.ID]; ok {
+				server.Added, server.Verified, server.Removed, server.Error = saved.Added, saved.Verified, saved.Removed, saved.Error
+			}
+			selected[s.ID] = server
+		}
+		state.Servers = selected
+
+		if dryRun {
+			printer.OutputAs("table", rotateReportOf(state))
+			return
+		}
+
+		save := func() {
+			if err := writeState(stateFile, state); err != nil {
+				fmt.Printf("Error saving rotation state : %v\n", err)
+			}
+		}
+
+		if len(state.NewKeyID) == 0 {
+			state.NewKeyID, err = registerKey(newKey, *keys)
+			if err != nil {
+				fmt.Printf("Error creating ssh key : %v\n", err)
+				os.Exit(1)
+			}
+			save()
+		}
+
+		hostKeys, err := hostKeyCallback(insecure)
+		if err != nil {
+			fmt.Printf("Error reading known hosts : %v\n", err)
+			os.Exit(1)
+		}
+		oldAuth, err := identityAuth(identity, oldKey)
+		if err != nil {
+			fmt.Printf("Error loading the old private key : %v\n", err)
+			os.Exit(1)
+		}
+		newAuth, err := identityAuth(newIdentity, newKey)
+		if err != nil {
+			fmt.Printf("Error loading the new private key : %v\n", err)
+			os.Exit(1)
+		}
+		config := func(auth ssh.AuthMethod) *ssh.ClientConfig {
+			return &ssh.ClientConfig{
+				User:            user,
+				Auth:            []ssh.AuthMethod{auth},
+				HostKeyCallback: hostKeys,
+				Timeout:         30 * time.Second,
+			}
+		}
+
+		for _, s := range rotateReportOf(state) {
+			s.Error = ""
+			if len(s.IP) == 0 {
+				s.Error = "no IPv4 address"
+				save()
+				continue
+			}
+			addr := net.JoinHostPort(s.IP, "22")
+
+			if !s.Added {
+				line := strings.TrimSpace(newKey.Content)
+				err = runRemote(addr, config(oldAuth), "mkdir -p ~/.ssh && chmod 700 ~/.ssh && touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys && "+
+					"(grep -qF "+shellQuote(keyBlob(newKey))+" ~/.ssh/authorized_keys || echo "+shellQuote(line)+" >> ~/.ssh/authorized_keys)")
+				if err != nil {
+					s.Error = "adding new key: " + err.Error()
+					save()
+					continue
+				}
+				s.Added = true
+				save()
+			}
+
+			if !s.Verified {
+				if err := runRemote(addr, config(newAuth), "true"); err != nil {
+					s.Error = "logging in with new key: " + err.Error()
+					save()
+					continue
+				}
+				s.Verified = true
+				save()
+			}
+
+			if !s.Removed {
+				// grep exits 1 when no lines are left, only 2 is an error
+				err = runRemote(addr, config(newAuth), "{ grep -vF "+shellQuote(keyBlob(oldKey))+" ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.blcli || [ $? -eq 1 ]; } && "+
+					"chmod 600 ~/.ssh/authorized_keys.blcli && mv -f ~/.ssh/authorized_keys.blcli ~/.ssh/authorized_keys")
+				if err != nil {
+					s.Error = "removing old key: " + err.Error()
+					save()
+					continue
+				}
+				s.Removed = true
+				save()
+			}
+		}
+
+		done := true
+		for _, s := range state.Servers {
+			done = done && s.Removed
+		}
+		if done && !state.OldKeyDeleted {
+			if err := client.SSHKey.Delete(oldID); err != nil {
+				fmt.Printf("Error deleting ssh key : %v\n", err)
+				done = false
+			} else {
+				state.OldKeyDeleted = true
+				save()
+			}
+		}
+
+		printer.OutputAs("table", rotateReportOf(state))
+		if !done {
+			fmt.Println("\nRotation incomplete, fix the errors above and run the same command again to resume")
+			os.Exit(1)
+		}
+		fmt.Printf("\nRotated ssh key %s to %s\n", oldID, state.NewKeyID)
+	},
+}
+
+// rotateReportOf returns the servers of a rotation ordered by name and ID
+func rotateReportOf(state rotateState) rotateReport {
+	ids := []string{}
+	for id := range state.Servers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := state.Servers[ids[i]], state.Servers[ids[j]]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return ids[i] < ids[j]
+	})
+
+	report := rotateReport{}
+	for _, id := range ids {
+		report = append(report, state.Servers[id])
+	}
+	return report
+}
+
+// registerKey uploads a key unless the account already has it, and returns
+// its ID
+func registerKey(key *publicKey, keys []gobitlaunch.SSHKey) (string, error) {
+	fingerprint := ssh.FingerprintSHA256(key.Key)
+	for _, k := range keys {
+		if existing, err := parsePublicKey(k.Content); err == nil && ssh.FingerprintSHA256(existing.Key) == fingerprint {
+			return k.ID, nil
+		}
+	}
+
+	name := key.Comment
+	if len(name) == 0 {
+		name = "rotated-" + time.Now().Format("2006-01-02")
+	}
+	created, err := client.SSHKey.Create(&gobitlaunch.SSHKey{Name: name, Content: key.Content})
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// identityAuth authenticates with the private key at path, asking for its
+// passphrase when needed, or with the ssh-agent key matching pub when path
+// is empty or missing
+func identityAuth(path string, pub *publicKey) (ssh.AuthMethod, error) {
+	fingerprint := ssh.FingerprintSHA256(pub.Key)
+	if len(path) == 0 {
+		path = localKeys()[fingerprint]
+	}
+
+	if len(path) > 0 && path != "ssh-agent" {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			signer, err := ssh.ParsePrivateKey(data)
+			if _, ok := err.(*ssh.PassphraseMissingError); ok {
+				var passphrase []byte
+				passphrase, err = readPassphrase(fmt.Sprintf("Passphrase for %s: ", path))
+				if err != nil {
+					return nil, err
+				}
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ssh.FingerprintSHA256(signer.PublicKey()) != fingerprint {
+				return nil, fmt.Errorf("%s does not match key %s", path, fingerprint)
+			}
+			return ssh.PublicKeys(signer), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, err
+		}
+		signers, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			return nil, err
+		}
+		for _, signer := range signers {
+			if ssh.FingerprintSHA256(signer.PublicKey()) == fingerprint {
+				return ssh.PublicKeys(signer), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no private key found for %s, use --identity", fingerprint)
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// hostKeyCallback checks server host keys against ~/.ssh/known_hosts
+func hostKeyCallback(insecure bool) (ssh.HostKeyCallback, error) {
+	if insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	dir, err := sshDir()
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.New(filepath.Join(dir, "known_hosts"))
+}
+
+// runRemote runs a shell command on a server
+func runRemote(addr string, config *ssh.ClientConfig, command string) error {
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if out, err := session.CombinedOutput(command); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// keyBlob returns the base64 part of a key, which identifies it in an
+// authorized_keys file whatever its options and comment
+func keyBlob(key *publicKey) string {
+	fields := strings.Fields(string(ssh.MarshalAuthorizedKey(key.Key)))
+	return fields[len(fields)-1]
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
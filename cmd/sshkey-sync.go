@@ -0,0 +1,257 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"./printer"
+	"github.com/bitlaunchio/gobitlaunch"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// namedKey is a public key wanted on the account
+type namedKey struct {
+	Name string
+	Key  *publicKey
+}
+
+// syncStep is a single change in an sshkey sync plan
+type syncStep struct {
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	ID          string `json:"id,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	Note        string `json:"note,omitempty"`
+
+	content string
+}
+
+type syncPlan []syncStep
+
+func (p syncPlan) Headers() []string {
+	return []string{"ACTION", "NAME", "ID", "FINGERPRINT", "NOTE"}
+}
+
+func (p syncPlan) Rows() [][]string {
+	rows := [][]string{}
+	for _, s := range p {
+		rows = append(rows, []string{s.Action, s.Name, s.ID, s.Fingerprint, s.Note})
+	}
+	return rows
+}
+
+var sshKeySync = &cobra.Command{
+	Use:   "sync",
+	Short: "Make the account's ssh keys match a directory or authorized_keys file",
+	Long: `sync --from <dir|authorized_keys> [--prune] [--yes]
+
+Uploads every key from the .pub files in a directory, named after the file,
+or from an authorized_keys file, named after the key comment, that is not on
+the account yet. With --prune, keys on the account that are not in the source
+are deleted, except keys still used by a server. The plan is shown and
+confirmed before any change; --dry-run only shows it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		prune, _ := cmd.Flags().GetBool("prune")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		wanted, err := readKeySource(from)
+		if err != nil {
+			fmt.Printf("Error reading ssh keys : %v\n", err)
+			os.Exit(1)
+		}
+
+		keys, err := client.SSHKey.List()
+		if err != nil {
+			fmt.Printf("Error listing ssh keys : %v\n", err)
+			os.Exit(1)
+		}
+		servers, err := client.Server.List()
+		if err != nil {
+			fmt.Printf("Error listing servers : %v\n", err)
+			os.Exit(1)
+		}
+
+		plan := planKeySync(wanted, *keys, *servers, prune)
+		printer.OutputAs("table", plan)
+
+		changes := 0
+		for _, step := range plan {
+			if step.Action == "create" || step.Action == "delete" {
+				changes++
+			}
+		}
+		if changes == 0 {
+			fmt.Println("\nSSH keys are in sync")
+			return
+		}
+		if dryRun {
+			return
+		}
+		if !yes {
+			fmt.Printf("\nApply %d changes? [y/N] ", changes)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+				fmt.Println("Cancelled")
+				return
+			}
+		}
+
+		failed := false
+		for _, step := range plan {
+			switch step.Action {
+			case "create":
+				created, err := client.SSHKey.Create(&gobitlaunch.SSHKey{Name: step.Name, Content: step.content})
+				if err != nil {
+					fmt.Printf("Error creating ssh key %s : %v\n", step.Name, err)
+					failed = true
+					continue
+				}
+				fmt.Printf("Created %s (%s)\n", created.Name, created.ID)
+			case "delete":
+				if err := client.SSHKey.Delete(step.ID); err != nil {
+					fmt.Printf("Error deleting ssh key %s : %v\n", step.Name, err)
+					failed = true
+					continue
+				}
+				fmt.Printf("Deleted %s (%s)\n", step.Name, step.ID)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// planKeySync works out which keys to create, keep and delete so the account
+// matches wanted. Keys are matched by fingerprint, so a renamed key is kept.
+func planKeySync(wanted []namedKey, keys []gobitlaunch.SSHKey, servers []gobitlaunch.Server, prune bool) syncPlan {
+	existing := map[string]gobitlaunch.SSHKey{}
+	for _, k := range keys {
+		if key, err := parsePublicKey(k.Content); err == nil {
+			existing[ssh.FingerprintSHA256(key.Key)] = k
+		}
+	}
+
+	plan := syncPlan{}
+	seen := map[string]bool{}
+	for _, w := range wanted {
+		fingerprint := ssh.FingerprintSHA256(w.Key.Key)
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		if k, ok := existing[fingerprint]; ok {
+			step := syncStep{Action: "keep", Name: k.Name, ID: k.ID, Fingerprint: fingerprint}
+			if k.Name != w.Name {
+				step.Note = fmt.Sprintf("named %s in source", w.Name)
+			}
+			plan = append(plan, step)
+			continue
+		}
+		plan = append(plan, syncStep{Action: "create", Name: w.Name, Fingerprint: fingerprint, content: w.Key.Content})
+	}
+
+	for _, k := range keys {
+		fingerprint := ""
+		if key, err := parsePublicKey(k.Content); err == nil {
+			fingerprint = ssh.FingerprintSHA256(key.Key)
+		}
+		if seen[fingerprint] {
+			continue
+		}
+
+		step := syncStep{Action: "delete", Name: k.Name, ID: k.ID, Fingerprint: fingerprint}
+		if used := serversUsingKey(k.ID, servers); len(used) > 0 {
+			step.Action = "keep"
+			step.Note = "not in source, used by " + strings.Join(used, ", ")
+		} else if !prune {
+			step.Action = "keep"
+			step.Note = "not in source, use --prune to delete"
+		}
+		plan = append(plan, step)
+	}
+
+	return plan
+}
+
+// serversUsingKey returns the names of the servers created with a key
+func serversUsingKey(id string, servers []gobitlaunch.Server) []string {
+	var names []string
+	for _, s := range servers {
+		if contains(s.SSHKeys, id) {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// readKeySource reads the keys of a directory of .pub files, each named after
+// its file, or of an authorized_keys file, each named after its comment
+func readKeySource(path string) ([]namedKey, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []namedKey
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(path, "*.pub"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			key, err := readPublicKeyFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s : %v", file, err)
+			}
+			keys = append(keys, namedKey{Name: strings.TrimSuffix(filepath.Base(file), ".pub"), Key: key})
+		}
+		return keys, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := parsePublicKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d : %v", path, i+1, err)
+		}
+		name := key.Comment
+		if len(name) == 0 {
+			name = fmt.Sprintf("%s-%d", filepath.Base(path), i+1)
+		}
+		keys = append(keys, namedKey{Name: name, Key: key})
+	}
+	return keys, nil
+}
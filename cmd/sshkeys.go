@@ -14,14 +14,21 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"./printer"
 	"github.com/bitlaunchio/gobitlaunch"
 
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 // SSHKey sets up the ssh key command and subcommands
@@ -36,11 +43,41 @@ func SSHKey() *cobra.Command {
 	cmd.AddCommand(sshKeyList)
 	cmd.AddCommand(sshKeyDelete)
 	cmd.AddCommand(sshKeyCreate)
+	cmd.AddCommand(sshKeyImport)
+	cmd.AddCommand(sshKeyGenerate)
+	cmd.AddCommand(sshKeySync)
+	cmd.AddCommand(sshKeyRotate)
 
-	sshKeyCreate.Flags().StringP("name", "n", "", "name for the new key")
+	sshKeyCreate.Flags().StringP("name", "n", "", "name for the new key (default is the key comment)")
 	sshKeyCreate.Flags().StringP("content", "c", "", "ssh key content")
-	sshKeyCreate.MarkFlagRequired("name")
-	sshKeyCreate.MarkFlagRequired("content")
+	sshKeyCreate.Flags().StringP("file", "f", "", "read the ssh key from a file, or - for stdin")
+
+	sshKeyList.Flags().StringP("format", "f", "json", "output format: json or table")
+
+	sshKeyImport.Flags().BoolP("yes", "y", false, "upload every key without asking")
+
+	sshKeyGenerate.Flags().StringP("name", "n", "", "name for the new key, also used as its comment")
+	sshKeyGenerate.Flags().StringP("type", "t", "ed25519", "key type: ed25519, rsa or ecdsa")
+	sshKeyGenerate.Flags().IntP("bits", "b", 0, "key size for rsa (default 4096) or ecdsa (default 256) keys")
+	sshKeyGenerate.Flags().StringP("out", "o", "", "path to write the private key to, the public key gets .pub appended (default ~/.ssh/bl_<name>)")
+	sshKeyGenerate.Flags().Bool("passphrase", false, "ask for a passphrase to encrypt the private key with")
+	sshKeyGenerate.MarkFlagRequired("name")
+
+	sshKeySync.Flags().String("from", "", "directory of .pub files or an authorized_keys file")
+	sshKeySync.Flags().Bool("prune", false, "delete keys that are not in the source")
+	sshKeySync.Flags().BoolP("yes", "y", false, "apply the plan without asking")
+	sshKeySync.MarkFlagRequired("from")
+
+	sshKeyRotate.Flags().String("old", "", "ID of the ssh key to replace")
+	sshKeyRotate.Flags().String("new", "", "public key file of the replacement key")
+	sshKeyRotate.Flags().StringP("identity", "i", "", "private key file of the old key (default is found in ~/.ssh or the ssh-agent)")
+	sshKeyRotate.Flags().String("new-identity", "", "private key file of the new key (default is --new without .pub)")
+	sshKeyRotate.Flags().StringP("user", "u", "root", "user to log in to the servers as")
+	sshKeyRotate.Flags().StringSlice("servers", []string{}, "server IDs to update, comma separated (default is every server created with the old key)")
+	sshKeyRotate.Flags().Bool("insecure-ignore-host-key", false, "do not check server host keys against ~/.ssh/known_hosts")
+	sshKeyRotate.MarkFlagRequired("old")
+	sshKeyRotate.MarkFlagRequired("new")
+	sshKeyRotate.RegisterFlagCompletionFunc("old", completeSSHKeys)
 
 	return cmd
 }
@@ -51,21 +88,70 @@ var sshKeyList = &cobra.Command{
 	Long:    ``,
 	Aliases: []string{"l"},
 	Run: func(cmd *cobra.Command, args []string) {
-		servers, err := client.SSHKey.List()
+		format, _ := cmd.Flags().GetString("format")
+
+		keys, err := client.SSHKey.List()
 		if err != nil {
 			fmt.Printf("Error listing ssh keys : %v\n", err)
 			os.Exit(1)
 		}
 
-		printer.Output(servers)
+		infos := sshKeyInfos{}
+		local := localKeys()
+		names := map[string][]string{}
+		for _, k := range *keys {
+			info := sshKeyInfo{SSHKey: k}
+			if key, err := parsePublicKey(k.Content); err == nil {
+				info.Type = key.Key.Type()
+				info.Bits = keyBits(key.Key)
+				info.SHA256 = ssh.FingerprintSHA256(key.Key)
+				info.MD5 = ssh.FingerprintLegacyMD5(key.Key)
+				info.Local = local[info.SHA256]
+				names[info.SHA256] = append(names[info.SHA256], k.Name)
+			}
+			infos = append(infos, info)
+		}
+
+		for fingerprint, n := range names {
+			if len(n) > 1 {
+				fmt.Fprintf(os.Stderr, "Warning: key %s is registered %d times, as %s\n", fingerprint, len(n), strings.Join(n, ", "))
+			}
+		}
+
+		printer.OutputAs(format, infos)
 	},
 }
 
+// sshKeyInfo is a key on the account with its type and fingerprints
+type sshKeyInfo struct {
+	gobitlaunch.SSHKey
+	Type   string `json:"type"`
+	Bits   int    `json:"bits"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+	Local  string `json:"local,omitempty"`
+}
+
+type sshKeyInfos []sshKeyInfo
+
+func (k sshKeyInfos) Headers() []string {
+	return []string{"ID", "NAME", "TYPE", "BITS", "SHA256", "MD5", "LOCAL"}
+}
+
+func (k sshKeyInfos) Rows() [][]string {
+	rows := [][]string{}
+	for _, info := range k {
+		rows = append(rows, []string{info.ID, info.Name, info.Type, strconv.Itoa(info.Bits), info.SHA256, info.MD5, info.Local})
+	}
+	return rows
+}
+
 var sshKeyDelete = &cobra.Command{
-	Use:     "delete",
-	Short:   "Permanently delete an ssh key",
-	Long:    `delete <key-id>`,
-	Aliases: []string{"delete", "d", "del", "rm"},
+	Use:               "delete",
+	Short:             "Permanently delete an ssh key",
+	Long:              `delete <key-id>`,
+	Aliases:           []string{"delete", "d", "del", "rm"},
+	ValidArgsFunction: completeSSHKeyArg,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide am ssh key ID")
@@ -74,6 +160,10 @@ var sshKeyDelete = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		id := args[0]
+		if printDryRun("sshkey delete", id, nil) {
+			return
+		}
+
 		err := client.SSHKey.Delete(id)
 		if err != nil {
 			fmt.Printf("Error deleting ssh key : %v\n", err)
@@ -92,14 +182,222 @@ var sshKeyCreate = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		opts := gobitlaunch.SSHKey{}
 		opts.Name, _ = cmd.Flags().GetString("name")
-		opts.Content, _ = cmd.Flags().GetString("content")
+		content, _ := cmd.Flags().GetString("content")
+		file, _ := cmd.Flags().GetString("file")
+
+		var key *publicKey
+		var err error
+		switch {
+		case len(content) > 0 && len(file) > 0:
+			err = errors.New("please provide either --content or --file, not both")
+		case len(file) > 0:
+			key, err = readPublicKeyFile(file)
+		case len(content) > 0:
+			key, err = parsePublicKey(content)
+		default:
+			err = errors.New("please provide the key with --content or --file")
+		}
+		if err != nil {
+			fmt.Printf("Error reading ssh key : %v\n", err)
+			os.Exit(1)
+		}
 
-		key, err := client.SSHKey.Create(&opts)
+		opts.Content = key.Content
+		if len(opts.Name) == 0 {
+			opts.Name = key.Comment
+		}
+		if len(opts.Name) == 0 {
+			fmt.Println("The key has no comment, please provide a --name")
+			os.Exit(1)
+		}
+
+		if printDryRun("sshkey create", "", opts) {
+			return
+		}
+
+		created, err := client.SSHKey.Create(&opts)
 		if err != nil {
 			fmt.Printf("Error creating ssh key : %v\n", err)
 			os.Exit(1)
 		}
 
-		printer.Output(key)
+		printer.Output(created)
 	},
 }
+
+var sshKeyImport = &cobra.Command{
+	Use:   "import",
+	Short: "Upload public keys from ~/.ssh",
+	Long: `import [file.pub...] [--yes]
+
+Uploads the given public key files, or asks about each ~/.ssh/*.pub file when
+none are given. Keys are named after their comment and keys already on the
+account are skipped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		files := args
+		if len(files) == 0 {
+			var err error
+			files, err = localPublicKeyFiles()
+			if err != nil {
+				fmt.Printf("Error listing ssh keys : %v\n", err)
+				os.Exit(1)
+			}
+			if len(files) == 0 {
+				fmt.Println("No public keys found in ~/.ssh")
+				return
+			}
+		} else {
+			yes = true
+		}
+
+		existing, err := client.SSHKey.List()
+		if err != nil {
+			fmt.Printf("Error listing ssh keys : %v\n", err)
+			os.Exit(1)
+		}
+		registered := map[string]string{}
+		for _, k := range *existing {
+			if key, err := parsePublicKey(k.Content); err == nil {
+				registered[ssh.FingerprintSHA256(key.Key)] = k.Name
+			}
+		}
+
+		in := bufio.NewReader(os.Stdin)
+		for _, file := range files {
+			key, err := readPublicKeyFile(file)
+			if err != nil {
+				fmt.Printf("Skipping %s : %v\n", file, err)
+				continue
+			}
+			fingerprint := ssh.FingerprintSHA256(key.Key)
+			if name, ok := registered[fingerprint]; ok {
+				fmt.Printf("Skipping %s, already on your account as %s\n", file, name)
+				continue
+			}
+
+			name := key.Comment
+			if len(name) == 0 {
+				name = strings.TrimSuffix(filepath.Base(file), ".pub")
+			}
+
+			if !yes {
+				fmt.Printf("Upload %s (%s %s) as %q? [y/N] ", file, key.Key.Type(), fingerprint, name)
+				answer, _ := in.ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					continue
+				}
+			}
+
+			opts := gobitlaunch.SSHKey{Name: name, Content: key.Content}
+			if printDryRun("sshkey create", "", opts) {
+				continue
+			}
+			created, err := client.SSHKey.Create(&opts)
+			if err != nil {
+				fmt.Printf("Error creating ssh key %s : %v\n", name, err)
+				continue
+			}
+			registered[fingerprint] = created.Name
+			fmt.Printf("Uploaded %s as %s (%s)\n", file, created.Name, created.ID)
+		}
+	},
+}
+
+var sshKeyGenerate = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new ssh key pair and upload it",
+	Long:  `generate --name <name> [--type ed25519|rsa|ecdsa] [--out ~/.ssh/bl_<name>] [--passphrase]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		keyType, _ := cmd.Flags().GetString("type")
+		bits, _ := cmd.Flags().GetInt("bits")
+		out, _ := cmd.Flags().GetString("out")
+		askPassphrase, _ := cmd.Flags().GetBool("passphrase")
+
+		if len(out) == 0 {
+			dir, err := sshDir()
+			if err != nil {
+				fmt.Printf("Error finding ~/.ssh : %v\n", err)
+				os.Exit(1)
+			}
+			out = filepath.Join(dir, "bl_"+name)
+		}
+		out, err := homedir.Expand(out)
+		if err != nil {
+			fmt.Printf("Error finding %s : %v\n", out, err)
+			os.Exit(1)
+		}
+
+		key, err := generateKey(keyType, bits)
+		if err != nil {
+			fmt.Printf("Error generating ssh key : %v\n", err)
+			os.Exit(1)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			fmt.Printf("Error generating ssh key : %v\n", err)
+			os.Exit(1)
+		}
+		pub := signer.PublicKey()
+
+		opts := gobitlaunch.SSHKey{
+			Name:    name,
+			Content: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + commentSuffix(name),
+		}
+		if printDryRun("sshkey create", "", opts) {
+			return
+		}
+
+		var passphrase []byte
+		if askPassphrase {
+			passphrase, err = readNewPassphrase()
+			if err != nil {
+				fmt.Printf("Error reading passphrase : %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := writeKeyPair(out, key, pub, name, passphrase); err != nil {
+			fmt.Printf("Error writing ssh key : %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s and %s.pub\n", out, out)
+
+		created, err := client.SSHKey.Create(&opts)
+		if err != nil {
+			fmt.Printf("Error creating ssh key : %v\n", err)
+			fmt.Printf("The key pair was kept, upload it with: blcli sshkey create --file %s --name %s\n", shellQuote(out+".pub"), shellQuote(name))
+			os.Exit(1)
+		}
+
+		fmt.Printf("Created ssh key %s (%s)\n", created.Name, created.ID)
+	},
+}
+
+// readNewPassphrase asks for a passphrase on the terminal, twice
+func readNewPassphrase() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("stdin is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(first) != string(second) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return first, nil
+}
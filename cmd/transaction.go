@@ -17,14 +17,22 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"./printer"
 	"github.com/bitlaunchio/gobitlaunch"
-	"github.com/mdp/qrterminal"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+// cryptoSymbols are the currencies a transaction can be paid with
+var cryptoSymbols = []string{"BTC", "LTC", "ETH", "BCH", "NANO", "TRX", "SRN", "TEL"}
+
 // Transaction sets up the server command and subcommands
 func Transaction() *cobra.Command {
 	cmd := &cobra.Command{
@@ -38,11 +46,34 @@ func Transaction() *cobra.Command {
 	cmd.AddCommand(transactionList)
 	cmd.AddCommand(transactionGet)
 	cmd.AddCommand(transactionQRCode)
+	cmd.AddCommand(transactionWait)
+	cmd.AddCommand(transactionExport)
 	transactionGet.Flags().Bool("qr", false, "output transaction as qr code to terminal")
 	transactionCreate.Flags().Bool("qr", false, "output transaction as qr code to terminal")
 	transactionCreate.Flags().BoolP("lightning", "l", false, "optionally use lightning network valid for BTC and LTC up to 0.042 BTC or equivalent.")
+	transactionCreate.Flags().Bool("wait", false, "wait for the transaction to be paid, see transaction wait")
 	transactionList.Flags().IntP("page", "p", 1, "page number")
 	transactionList.Flags().IntP("items", "i", 25, "number of items per page")
+	transactionList.Flags().BoolP("all", "a", false, "fetch every page of transactions")
+	transactionList.Flags().Int("concurrency", 4, "how many pages to fetch at once with --all")
+	transactionList.Flags().String("since", "", "only show transactions from this date, format: YYYY-MM-DD")
+	transactionList.Flags().String("until", "", "only show transactions up to this date, format: YYYY-MM-DD")
+	transactionQRCode.Flags().StringP("out", "o", "", "write the QR code to a .png or .svg file instead of the terminal")
+	transactionQRCode.Flags().IntP("size", "s", 512, "width in pixels of the QR code written with --out")
+	transactionQRCode.Flags().Bool("caption", false, "print the amount and currency under the QR code written with --out")
+	transactionQRCode.Flags().StringP("format", "f", "qr", "output format: qr or uri")
+	transactionExport.Flags().StringP("format", "f", "csv", "export format: csv, ofx, qif, ledger or json")
+	transactionExport.Flags().String("from", "", "only export transactions from this date, format: YYYY-MM-DD")
+	transactionExport.Flags().String("to", "", "only export transactions up to this date, format: YYYY-MM-DD")
+	transactionExport.Flags().String("timezone", "UTC", "timezone to write dates in, e.g. Europe/London or Local")
+	transactionExport.Flags().Bool("include-pending", false, "also export payments that are not confirmed yet")
+	transactionExport.Flags().String("account", "Assets:BitLaunch", "ledger account the payments are credited to")
+	transactionExport.Flags().String("source", "Assets:Crypto", "ledger account the crypto is paid from, the coin is appended")
+	for _, c := range []*cobra.Command{transactionWait, transactionCreate} {
+		c.Flags().Duration("interval", 15*time.Second, "how often to check the transaction status when waiting")
+		c.Flags().Duration("timeout", 2*time.Hour, "how long to wait for payment")
+		c.Flags().Duration("expiry", time.Hour, "how long an invoice stays open after it is created")
+	}
 
 	return cmd
 }
@@ -52,6 +83,12 @@ var transactionCreate = &cobra.Command{
 	Short:   "Create a new transaction",
 	Long:    `create <amount-usd> <BTC, LTC, ETH, BCH, NANO, TRX, SRN, TEL>`,
 	Aliases: []string{"c", "create"},
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 1 {
+			return cryptoSymbols, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	},
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 2 {
 			return errors.New("create <amount-usd> <BTC, LTC, ETH, BCH, NANO, TRX, SRN, TEL>")
@@ -71,11 +108,20 @@ var transactionCreate = &cobra.Command{
 			fmt.Println("Lightning network only available for BTC and LTC")
 			os.Exit(1)
 		}
-		transaction, err := client.Transaction.Create(&gobitlaunch.CreateTransactionOptions{
+		if dryRun && !contains(cryptoSymbols, symbol) {
+			fmt.Printf("Unsupported cryptocurrency %s, must be one of: %s\n", symbol, strings.Join(cryptoSymbols, ", "))
+			os.Exit(1)
+		}
+		opts := gobitlaunch.CreateTransactionOptions{
 			AmountUSD:        usdInt,
 			CryptoSymbol:     symbol,
 			LightningNetwork: ln,
-		})
+		}
+		if printDryRun("transaction create", "", opts) {
+			return
+		}
+
+		transaction, err := client.Transaction.Create(&opts)
 		if err != nil {
 			fmt.Printf("Error creating a new transaction : %v\n", err)
 			os.Exit(1)
@@ -83,24 +129,24 @@ var transactionCreate = &cobra.Command{
 
 		qr, _ := cmd.Flags().GetBool("qr")
 		if qr {
-			if len(transaction.Address) == 0 || len(transaction.AmountCrypto) == 0 {
-				fmt.Println("Unable to generate a QR Code for this type of transaction.")
-				os.Exit(1)
-			}
-			s := fmt.Sprintf("bitcoin:%s?amount=%s", transaction.Address, transaction.AmountCrypto)
-			qrterminal.Generate(s, qrterminal.L, os.Stdout)
-			return
+			printQRCode(transaction)
+		} else {
+			printer.Output(transaction)
 		}
 
-		printer.Output(transaction)
+		wait, _ := cmd.Flags().GetBool("wait")
+		if wait {
+			os.Exit(waitForPayment(cmd, transaction))
+		}
 	},
 }
 
 var transactionGet = &cobra.Command{
-	Use:     "get",
-	Short:   "Get information for a single transaction",
-	Long:    `get <transaction-id>`,
-	Aliases: []string{"g", "show"},
+	Use:               "get",
+	Short:             "Get information for a single transaction",
+	Long:              `get <transaction-id>`,
+	Aliases:           []string{"g", "show"},
+	ValidArgsFunction: completeTransactions,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a transaction ID")
@@ -117,12 +163,7 @@ var transactionGet = &cobra.Command{
 
 		qr, _ := cmd.Flags().GetBool("qr")
 		if qr {
-			if len(transaction.Address) == 0 || len(transaction.AmountCrypto) == 0 {
-				fmt.Println("Unable to generate a QR Code for this type of transaction.")
-				os.Exit(1)
-			}
-			s := fmt.Sprintf("bitcoin:%s?amount=%s", transaction.Address, transaction.AmountCrypto)
-			qrterminal.Generate(s, qrterminal.L, os.Stdout)
+			printQRCode(transaction)
 			return
 		}
 
@@ -133,25 +174,160 @@ var transactionGet = &cobra.Command{
 var transactionList = &cobra.Command{
 	Use:     "list",
 	Short:   "List transactions on your account",
-	Long:    `list --page [page-number|1] --items [items-per-page|25]`,
+	Long:    `list --page [page-number|1] --items [items-per-page|25] | --all [--since YYYY-MM-DD] [--until YYYY-MM-DD]`,
 	Aliases: []string{"l"},
 	Run: func(cmd *cobra.Command, args []string) {
 		page, _ := cmd.Flags().GetInt("page")
 		items, _ := cmd.Flags().GetInt("items")
-		transactions, err := client.Transaction.List(page, items)
+		all, _ := cmd.Flags().GetBool("all")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+
+		dates, err := parseDateRange(since, until, time.UTC)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		var transactions []gobitlaunch.Transaction
+		if all {
+			transactions, err = allTransactions(items, concurrency)
+		} else {
+			var list *[]gobitlaunch.Transaction
+			list, err = client.Transaction.List(page, items)
+			if list != nil {
+				transactions = *list
+			}
+		}
 		if err != nil {
 			fmt.Printf("Error listing transactions : %v\n", err)
 			os.Exit(1)
 		}
 
-		printer.Output(transactions)
+		filtered := []gobitlaunch.Transaction{}
+		for _, t := range transactions {
+			if dates.includes(t.Date) {
+				filtered = append(filtered, t)
+			}
+		}
+
+		printer.Output(filtered)
 	},
 }
 
+// allTransactions fetches every page of transactions, without duplicates
+func allTransactions(items, concurrency int) ([]gobitlaunch.Transaction, error) {
+	if items < 1 {
+		items = 25
+	}
+
+	var mu sync.Mutex
+	results := map[int][]gobitlaunch.Transaction{}
+	// the API may return fewer items than asked for, so only an empty page
+	// means there are no more
+	err := fetchPages(0, 0, concurrency, func(page int) (int, error) {
+		list, err := client.Transaction.List(page, items)
+		if err != nil {
+			return 0, err
+		}
+		mu.Lock()
+		results[page] = *list
+		mu.Unlock()
+		return len(*list), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []gobitlaunch.Transaction{}
+	seen := map[string]bool{}
+	for page := 1; page <= len(results); page++ {
+		for _, t := range results[page] {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				transactions = append(transactions, t)
+			}
+		}
+	}
+	return transactions, nil
+}
+
 var transactionQRCode = &cobra.Command{
-	Use:   "qr",
-	Short: "Generate a QR code for a transaction",
-	Long:  `qr <transaction-id>`,
+	Use:               "qr",
+	Short:             "Generate a QR code for a transaction",
+	Long:              `qr <transaction-id> [--out pay.png|pay.svg] [--size 512] [--caption] [--format qr|uri]`,
+	ValidArgsFunction: completeTransactions,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("please provide a transaction ID")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+		transaction, err := client.Transaction.Show(id)
+		if err != nil {
+			fmt.Printf("Error getting transaction : %v\n", err)
+			os.Exit(1)
+		}
+
+		out, _ := cmd.Flags().GetString("out")
+		size, _ := cmd.Flags().GetInt("size")
+		caption, _ := cmd.Flags().GetBool("caption")
+		format, _ := cmd.Flags().GetString("format")
+
+		switch {
+		case format == "uri":
+			uri, err := paymentURI(transaction)
+			if err != nil {
+				fmt.Printf("Unable to generate a payment URI for this transaction : %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(uri)
+		case format != "qr":
+			fmt.Println("Unknown format, must be one of: qr, uri")
+			os.Exit(1)
+		case len(out) > 0:
+			uri, err := paymentURI(transaction)
+			if err != nil {
+				fmt.Printf("Unable to generate a QR Code for this transaction : %v\n", err)
+				os.Exit(1)
+			}
+			text := ""
+			if caption {
+				text = fmt.Sprintf("%s %s ($%d)", transaction.AmountCrypto, transaction.Symbol, transaction.AmountUSD)
+			}
+			if err := writeQRFile(out, uri, text, size); err != nil {
+				fmt.Printf("Error writing QR code : %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Wrote QR code to %s\n", out)
+		default:
+			printQRCode(transaction)
+		}
+	},
+}
+
+// clearLine returns the terminal cursor to the start of an empty line
+const clearLine = "\r\033[K"
+
+// Exit codes of transaction wait
+const (
+	exitPaymentExpired   = 2
+	exitPaymentUnderpaid = 3
+	exitPaymentTimeout   = 4
+)
+
+var transactionWait = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait for a transaction to be paid",
+	Long: `wait <transaction-id> [--interval 15s] [--timeout 2h]
+
+Polls the transaction until it is confirmed, printing each status change.
+Exits with 0 once confirmed, 2 if the invoice expired, 3 if it was
+underpaid and 4 if --timeout passed first.`,
+	ValidArgsFunction: completeTransactions,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("please provide a transaction ID")
@@ -166,13 +342,177 @@ var transactionQRCode = &cobra.Command{
 			os.Exit(1)
 		}
 
-		if len(transaction.Address) == 0 || len(transaction.AmountCrypto) == 0 {
-			fmt.Println("Unable to generate a QR Code for this type of transaction.")
+		os.Exit(waitForPayment(cmd, transaction))
+	},
+}
+
+// paymentState is the outcome a transaction status represents
+type paymentState int
+
+const (
+	paymentPending paymentState = iota
+	paymentConfirmed
+	paymentExpired
+	paymentUnderpaid
+)
+
+// transactionState classifies a status by its words, so that "unpaid" or
+// "not confirmed" are not taken for "paid" or "confirmed"
+func transactionState(status string) paymentState {
+	words := strings.FieldsFunc(strings.ToLower(status), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	has := func(values ...string) bool {
+		for _, w := range words {
+			if contains(values, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("unpaid", "unconfirmed", "not", "waiting", "pending", "new"):
+		return paymentPending
+	case has("underpaid", "partial", "partially"):
+		return paymentUnderpaid
+	case has("expired", "cancelled", "canceled", "timeout", "invalid"):
+		return paymentExpired
+	case has("confirmed", "complete", "completed", "paid", "settled"):
+		return paymentConfirmed
+	default:
+		return paymentPending
+	}
+}
+
+// waitForPayment polls a transaction until it leaves the pending state or
+// the --timeout flag passes, showing the time left on the invoice, and
+// returns the exit code for the outcome
+func waitForPayment(cmd *cobra.Command, t *gobitlaunch.Transaction) int {
+	interval, _ := cmd.Flags().GetDuration("interval")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	expiry, _ := cmd.Flags().GetDuration("expiry")
+
+	deadline := time.Now().Add(timeout)
+	expires := t.Date.Add(expiry)
+	status := t.Status
+	fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), status)
+
+	// the countdown is only drawn on a terminal, so logs under cron only get
+	// the status changes
+	erase := ""
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		erase = clearLine
+	} else {
+		tick.Stop()
+	}
+	poll := time.NewTicker(interval)
+	defer poll.Stop()
+
+	for {
+		switch transactionState(status) {
+		case paymentConfirmed:
+			fmt.Fprint(os.Stderr, erase)
+			return 0
+		case paymentExpired:
+			fmt.Fprint(os.Stderr, erase)
+			return exitPaymentExpired
+		case paymentUnderpaid:
+			fmt.Fprint(os.Stderr, erase)
+			return exitPaymentUnderpaid
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Fprint(os.Stderr, erase)
+			fmt.Println("Timed out waiting for payment")
+			return exitPaymentTimeout
+		}
+
+		select {
+		case <-tick.C:
+			left := time.Until(expires).Truncate(time.Second)
+			if left < 0 {
+				left = 0
+			}
+			fmt.Fprintf(os.Stderr, "%sWaiting for payment, invoice expires in %s", erase, left)
+		case <-poll.C:
+			latest, err := client.Transaction.Show(t.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sError getting transaction : %v\n", erase, err)
+				continue
+			}
+			if latest.Status != status {
+				status = latest.Status
+				fmt.Fprint(os.Stderr, erase)
+				fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), status)
+			}
+		}
+	}
+}
+
+var transactionExport = &cobra.Command{
+	Use:   "export",
+	Short: "Export confirmed payments for bookkeeping",
+	Long: `export [--format csv|ofx|qif|ledger|json] [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--timezone UTC]
+
+Exports every confirmed payment, oldest first, with its USD and crypto
+amounts. Use --include-pending to add payments that are still open;
+expired payments are never exported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		pending, _ := cmd.Flags().GetBool("include-pending")
+		account, _ := cmd.Flags().GetString("account")
+		source, _ := cmd.Flags().GetString("source")
+
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			fmt.Printf("Invalid timezone : %v\n", err)
+			os.Exit(1)
+		}
+		dates, err := parseDateRange(from, to, loc)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		transactions, err := allTransactions(100, 4)
+		if err != nil {
+			fmt.Printf("Error listing transactions : %v\n", err)
 			os.Exit(1)
 		}
 
-		s := fmt.Sprintf("bitcoin:%s?amount=%s", transaction.Address, transaction.AmountCrypto)
+		entries := ledgerEntries{}
+		for _, t := range transactions {
+			state := transactionState(t.Status)
+			if state == paymentExpired || (state != paymentConfirmed && !pending) {
+				continue
+			}
+			if !dates.includes(t.Date) {
+				continue
+			}
+			entries = append(entries, newLedgerEntry(t, loc))
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Date.Before(entries[j].Date)
+		})
 
-		qrterminal.Generate(s, qrterminal.L, os.Stdout)
+		switch format {
+		case "csv", "json":
+			printer.OutputAs(format, entries)
+		case "qif":
+			writeQIF(os.Stdout, entries)
+		case "ofx":
+			writeOFX(os.Stdout, entries, dates.since, dates.until)
+		case "ledger":
+			writeLedger(os.Stdout, entries, account, source)
+		default:
+			fmt.Println("Unknown format, must be one of: csv, ofx, qif, ledger, json")
+			os.Exit(1)
+		}
 	},
 }
@@ -0,0 +1,229 @@
+/*
+Copyright 2020 The blcli Authors All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitlaunchio/gobitlaunch"
+)
+
+// choice is a selectable value from a host's create options
+type choice struct {
+	ID          string
+	Aliases     []string
+	Description string
+}
+
+// is reports whether value names this choice exactly
+func (c choice) is(value string) bool {
+	if strings.EqualFold(c.ID, value) {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if strings.EqualFold(alias, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveServerOptions checks the image, size and region of a new server
+// against the create options available for its host. Names such as
+// "ubuntu 22.04", "london" or "2gb" are replaced with their ids.
+func resolveServerOptions(opts *gobitlaunch.CreateServerOptions) error {
+	co, err := cachedCreateOptions(opts.HostID)
+	if err != nil {
+		return err
+	}
+
+	image, err := resolveChoice("image", opts.HostImageID, imageChoices(co))
+	if err != nil {
+		return err
+	}
+	size, err := resolveChoice("size", opts.SizeID, sizeChoices(co))
+	if err != nil {
+		return err
+	}
+	region, err := resolveChoice("region", opts.RegionID, regionChoices(co))
+	if err != nil {
+		return err
+	}
+
+	opts.HostImageID = image.ID
+	opts.SizeID = size.ID
+	opts.RegionID = region.ID
+	return nil
+}
+
+// resolveImage finds an image by id or name in the create options of a host
+func resolveImage(host int, value string) (choice, error) {
+	co, err := cachedCreateOptions(host)
+	if err != nil {
+		return choice{}, err
+	}
+	return resolveChoice("image", value, imageChoices(co))
+}
+
+// validateSize checks a size id against the create options of a host
+func validateSize(host int, id string) error {
+	co, err := cachedCreateOptions(host)
+	if err != nil {
+		return err
+	}
+	_, err = resolveChoice("size", id, sizeChoices(co))
+	return err
+}
+
+// resolveChoice finds the choice named by value. An exact id or alias wins,
+// then a description equal to value, then the only choice that matches value
+// by words. Several word matches are reported as ambiguous.
+func resolveChoice(kind, value string, choices []choice) (choice, error) {
+	for _, c := range choices {
+		if c.is(value) {
+			return c, nil
+		}
+	}
+	for _, c := range choices {
+		if strings.EqualFold(c.Description, value) {
+			return c, nil
+		}
+	}
+
+	var matches []choice
+	for _, c := range choices {
+		if fuzzyScore(value, c) == 0 {
+			matches = append(matches, c)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return choice{}, notAvailable(kind, value, choices)
+	case 1:
+		return matches[0], nil
+	default:
+		return choice{}, fmt.Errorf("%s %q is ambiguous, it matches:%s", kind, value, choiceList(matches))
+	}
+}
+
+// notAvailable builds the error for an unknown value, listing the closest
+// choices as suggestions
+func notAvailable(kind, value string, choices []choice) error {
+	msg := fmt.Sprintf("%s %q is not available for this host", kind, value)
+
+	suggestions := suggest(value, choices, 5)
+	if len(suggestions) == 0 {
+		return errors.New(msg)
+	}
+	return errors.New(msg + ", did you mean:" + choiceList(suggestions))
+}
+
+// choiceList renders choices one per line with aligned ids
+func choiceList(choices []choice) string {
+	width := 0
+	for _, c := range choices {
+		if len(c.ID) > width {
+			width = len(c.ID)
+		}
+	}
+
+	var list string
+	for _, c := range choices {
+		list += fmt.Sprintf("\n  %-*s  %s", width, c.ID, c.Description)
+	}
+	return list
+}
+
+func imageChoices(co *gobitlaunch.CreateOptions) []choice {
+	var choices []choice
+	for _, image := range co.Image {
+		choices = append(choices, choice{ID: image.ID, Description: image.Name})
+		for _, version := range image.Versions {
+			if version.ID == image.ID {
+				continue
+			}
+			desc := version.Description
+			if !strings.Contains(strings.ToLower(desc), strings.ToLower(image.Name)) {
+				desc = image.Name + " " + desc
+			}
+			choices = append(choices, choice{ID: version.ID, Description: desc})
+		}
+	}
+	return choices
+}
+
+// imageDescription returns the API's description of an image version, or
+// the image name for an image id
+func imageDescription(host int, id string) string {
+	co, err := cachedCreateOptions(host)
+	if err != nil {
+		return ""
+	}
+	for _, image := range co.Image {
+		for _, version := range image.Versions {
+			if version.ID == id {
+				return version.Description
+			}
+		}
+		if image.ID == id {
+			return image.Name
+		}
+	}
+	return ""
+}
+
+func sizeChoices(co *gobitlaunch.CreateOptions) []choice {
+	var choices []choice
+	for _, size := range co.Size {
+		c := choice{
+			ID: size.ID,
+			Description: fmt.Sprintf("%d CPU, %s memory, %dGB disk, $%.2f/mo",
+				size.CPUCount, memoryString(size.MemoryMB), size.DiskGB, size.CostPerMonth),
+		}
+		if size.Slug != "" && size.Slug != size.ID {
+			c.Aliases = []string{size.Slug}
+		}
+		choices = append(choices, c)
+	}
+	return choices
+}
+
+func regionChoices(co *gobitlaunch.CreateOptions) []choice {
+	var choices []choice
+	for _, region := range co.Region {
+		if len(region.SubRegions) == 0 {
+			choices = append(choices, choice{ID: region.ID, Description: region.Name})
+			continue
+		}
+		for _, sub := range region.SubRegions {
+			c := choice{ID: sub.ID, Description: strings.TrimSpace(region.Name + " " + sub.Description)}
+			if sub.Slug != "" && sub.Slug != sub.ID {
+				c.Aliases = []string{sub.Slug}
+			}
+			choices = append(choices, c)
+		}
+	}
+	return choices
+}
+
+// memoryString formats a memory size given in MB
+func memoryString(mb int) string {
+	if mb >= 1024 && mb%1024 == 0 {
+		return fmt.Sprintf("%dGB", mb/1024)
+	}
+	return fmt.Sprintf("%dMB", mb)
+}